@@ -0,0 +1,439 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bdclark/nomadctl/deploy"
+	"github.com/bdclark/nomadctl/logging"
+	consul "github.com/hashicorp/consul/api"
+	"github.com/spf13/cobra"
+)
+
+var agentCmd = &cobra.Command{
+	Use:   "agent [PREFIX]",
+	Short: "Deploy jobs whenever their job keys change",
+	Long: `Runs until interrupted, watching the job keys under PREFIX (or the
+configured prefix) in Consul with blocking queries, and deploying each job
+whose keys change, so that "nomadctl kv set" is all that is needed to
+deploy a job.
+
+A job is deployed when the highest modify index of the keys under its job
+key changes, see "nomadctl help deploy kv" for details regarding job keys.
+Changes to a template stored outside the job key, such as a file or URL
+"template/source", are not detected. Unless the "sync-on-start" flag is
+set, the jobs found at startup are not deployed until they change.
+
+Each job is rendered and deployed exactly as "nomadctl deploy kv" would,
+using its own Consul settings. Different jobs are deployed concurrently,
+but a job is never deployed more than once at a time: changes made while
+it is being deployed are deployed once the deployment completes. Failed
+deployments are retried with exponential backoff, starting at "backoff"
+and up to "max-backoff", until they succeed or the job key changes again.
+Job keys that are removed are forgotten, but their jobs are not stopped,
+see "nomadctl help reconcile".
+
+With the "dry-run" flag, each changed job is planned instead, and the
+plan is logged rather than deployed.
+
+The status of each job is served as JSON at "/status" on the "listen"
+address, unless it is empty. When interrupted, running deployments are
+detached from and the agent exits.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := initConfig(cmd); err != nil {
+			bail(err, 1)
+		}
+
+		prefix, err := jobPrefix(args)
+		if err != nil {
+			usageError(cmd, err.Error())
+		}
+
+		a := newAgent(cmd, prefix)
+		if err := a.run(); err != nil {
+			bail(err, 1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(agentCmd)
+
+	addConfigFlags(agentCmd)
+	addConsulFlags(agentCmd)
+	agentCmd.Flags().String("listen", "127.0.0.1:4650", "address to serve the status endpoint on, empty to disable")
+	agentCmd.Flags().Bool("dry-run", false, "log the plan of changed jobs instead of deploying them")
+	agentCmd.Flags().Bool("sync-on-start", false, "deploy every job when the agent starts")
+	agentCmd.Flags().Duration("backoff", 30*time.Second, "time to wait before retrying a failed deployment")
+	agentCmd.Flags().Duration("max-backoff", 10*time.Minute, "maximum time to wait before retrying a failed deployment")
+}
+
+// agentWaitTime is how long each blocking query waits for a change
+const agentWaitTime = 5 * time.Minute
+
+// agent watches job keys and deploys the jobs whose keys change
+type agent struct {
+	cmd         *cobra.Command
+	prefix      string
+	dryRun      bool
+	syncOnStart bool
+	backoff     time.Duration
+	maxBackoff  time.Duration
+
+	renderMu sync.Mutex // rendering uses the global config, so one job at a time
+	wg       sync.WaitGroup
+
+	mu         sync.Mutex
+	jobs       map[string]*agentJob
+	watchError string
+	stopped    bool // no more workers are started once stopped
+}
+
+// agentJob is the state of a single job key, and is serialized as its status
+type agentJob struct {
+	Key          string     `json:"key"`
+	Status       string     `json:"status"`
+	ModifyIndex  uint64     `json:"modify_index"`  // highest modify index of the job key
+	AppliedIndex uint64     `json:"applied_index"` // modify index last deployed (or planned)
+	DeploymentID string     `json:"deployment_id,omitempty"`
+	LastRun      *time.Time `json:"last_run,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	Failures     int        `json:"failures"`
+	NextRetry    *time.Time `json:"next_retry,omitempty"`
+
+	trigger chan struct{}
+}
+
+// agent job statuses
+const (
+	agentJobWatching  = "watching"
+	agentJobPending   = "pending"
+	agentJobDeploying = "deploying"
+	agentJobDeployed  = "deployed"
+	agentJobPlanning  = "planning"
+	agentJobPlanned   = "planned"
+	agentJobFailed    = "failed"
+	agentJobRemoved   = "removed"
+)
+
+func newAgent(cmd *cobra.Command, prefix string) *agent {
+	a := &agent{
+		cmd:    cmd,
+		prefix: prefix,
+		jobs:   make(map[string]*agentJob),
+	}
+	a.dryRun, _ = cmd.Flags().GetBool("dry-run")
+	a.syncOnStart, _ = cmd.Flags().GetBool("sync-on-start")
+	a.backoff, _ = cmd.Flags().GetDuration("backoff")
+	a.maxBackoff, _ = cmd.Flags().GetDuration("max-backoff")
+	return a
+}
+
+// run watches the prefix until interrupted
+func (a *agent) run() error {
+	client, err := consulClient()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigCh
+		logging.Warning("received %s, shutting down", sig)
+		cancel()
+	}()
+
+	var server *http.Server
+	if listen, _ := a.cmd.Flags().GetString("listen"); listen != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/status", a.handleStatus)
+		server = &http.Server{Addr: listen, Handler: mux}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logging.Error("status endpoint failed: %v", err)
+			}
+		}()
+		logging.Info("serving status at http://%s/status", listen)
+	}
+
+	logging.Info("watching job keys under \"%s\"", a.prefix)
+	if a.dryRun {
+		logging.Info("dry run, changed jobs will be planned but not deployed")
+	}
+	go a.watch(ctx, client)
+
+	<-ctx.Done()
+	a.mu.Lock()
+	a.stopped = true
+	a.mu.Unlock()
+	a.wg.Wait()
+	if server != nil {
+		server.Close()
+	}
+	return nil
+}
+
+// watch lists the prefix with blocking queries, updating the jobs
+// with each result, until the context is done
+func (a *agent) watch(ctx context.Context, client *consul.Client) {
+	var index uint64
+	failures := 0
+	first := true
+
+	for ctx.Err() == nil {
+		pairs, meta, err := client.KV().List(a.prefix+"/", &consul.QueryOptions{
+			WaitIndex: index,
+			WaitTime:  agentWaitTime,
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			failures++
+			wait := a.backoffTime(failures)
+			logging.Error("failed to list job keys, retrying in %s: %v", wait, err)
+			a.mu.Lock()
+			a.watchError = err.Error()
+			a.mu.Unlock()
+			select {
+			case <-ctx.Done():
+			case <-time.After(wait):
+			}
+			continue
+		}
+		failures = 0
+
+		// reset the index if it goes backwards, such as after a snapshot restore
+		if meta.LastIndex < index {
+			index = 0
+		} else {
+			index = meta.LastIndex
+		}
+
+		a.update(ctx, pairs, first)
+		first = false
+	}
+}
+
+// update compares the modify index of each job key with the last one
+// seen, and triggers the deployment of each job key that changed
+func (a *agent) update(ctx context.Context, pairs consul.KVPairs, first bool) {
+	indexes := make(map[string]uint64)
+	for _, pair := range pairs {
+		parts := strings.SplitN(strings.TrimPrefix(pair.Key, a.prefix+"/"), "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[0] == deploy.RecordsKey {
+			continue
+		}
+		// approvals are recorded by deployments, and are not changes to the job
+		if strings.HasPrefix(parts[1], deploy.ApprovalsKey+"/") {
+			continue
+		}
+		if pair.ModifyIndex > indexes[parts[0]] {
+			indexes[parts[0]] = pair.ModifyIndex
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.stopped {
+		return
+	}
+	a.watchError = ""
+
+	for key, index := range indexes {
+		job, ok := a.jobs[key]
+		if !ok {
+			job = &agentJob{Key: key, Status: agentJobWatching, trigger: make(chan struct{}, 1)}
+			a.jobs[key] = job
+			a.wg.Add(1)
+			go a.work(ctx, job)
+		}
+		if index == job.ModifyIndex {
+			continue
+		}
+		job.ModifyIndex = index
+
+		if first && !a.syncOnStart {
+			job.AppliedIndex = index
+			continue
+		}
+
+		logging.Info("job key \"%s\" changed (modify index %d)", key, index)
+		job.Failures = 0
+		job.NextRetry = nil
+		if job.Status != agentJobDeploying && job.Status != agentJobPlanning {
+			job.Status = agentJobPending
+		}
+		select {
+		case job.trigger <- struct{}{}:
+		default:
+			// already triggered
+		}
+	}
+
+	for key, job := range a.jobs {
+		if _, ok := indexes[key]; !ok && job.Status != agentJobRemoved {
+			logging.Warning("job key \"%s\" removed, its job will not be stopped", key)
+			job.Status = agentJobRemoved
+			job.ModifyIndex = 0
+		}
+	}
+}
+
+// work deploys a job each time it is triggered, retrying failed
+// deployments with backoff, until the context is done
+func (a *agent) work(ctx context.Context, job *agentJob) {
+	defer a.wg.Done()
+
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-job.trigger:
+		case <-retry:
+		}
+		retry = nil
+
+		a.mu.Lock()
+		if job.Status == agentJobRemoved {
+			a.mu.Unlock()
+			continue
+		}
+		index := job.ModifyIndex
+		job.Status = agentJobDeploying
+		if a.dryRun {
+			job.Status = agentJobPlanning
+		}
+		job.NextRetry = nil
+		a.mu.Unlock()
+
+		deploymentID, err := a.sync(ctx, job.Key)
+		if ctx.Err() != nil {
+			return
+		}
+
+		a.mu.Lock()
+		now := time.Now()
+		job.LastRun = &now
+		job.DeploymentID = deploymentID
+		if err != nil {
+			job.Failures++
+			wait := a.backoffTime(job.Failures)
+			next := now.Add(wait)
+			job.Status = agentJobFailed
+			job.LastError = err.Error()
+			job.NextRetry = &next
+			retry = time.After(wait)
+			logging.Error("job key \"%s\" failed, retrying in %s: %v", job.Key, wait, err)
+		} else {
+			job.Status = agentJobDeployed
+			if a.dryRun {
+				job.Status = agentJobPlanned
+			}
+			if job.ModifyIndex != index {
+				job.Status = agentJobPending
+			}
+			job.AppliedIndex = index
+			job.LastError = ""
+			job.Failures = 0
+		}
+		a.mu.Unlock()
+	}
+}
+
+// sync renders and deploys (or plans) the job defined by a job key,
+// and returns the ID of the resulting deployment, if any
+func (a *agent) sync(ctx context.Context, key string) (string, error) {
+	a.renderMu.Lock()
+	job, err := renderBatchJob(a.cmd, &batchJobSpec{
+		name:     key,
+		key:      key,
+		settings: map[string]interface{}{"prefix": a.prefix},
+	}, false)
+	a.renderMu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	if a.dryRun {
+		var out bytes.Buffer
+		if _, err := job.Deployment.Plan(&deploy.PlanInput{Diff: true, NoColor: true, Out: &out}); err != nil {
+			return "", err
+		}
+		log := logging.NewLogger(key)
+		scanner := bufio.NewScanner(&out)
+		for scanner.Scan() {
+			if line := scanner.Text(); line != "" {
+				log.Info("%s", line)
+			}
+		}
+		return "", nil
+	}
+
+	success, err := job.Deployment.Deploy(ctx)
+	if err == nil && !success {
+		err = fmt.Errorf("deployment unsuccessful")
+	}
+	return job.Deployment.DeploymentID(), err
+}
+
+// backoffTime returns how long to wait after the given number of failures
+func (a *agent) backoffTime(failures int) time.Duration {
+	wait := a.backoff
+	for i := 1; i < failures && wait < a.maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > a.maxBackoff {
+		wait = a.maxBackoff
+	}
+	return wait
+}
+
+// handleStatus serves the status of the agent and each job as JSON
+func (a *agent) handleStatus(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	status := struct {
+		Prefix     string      `json:"prefix"`
+		DryRun     bool        `json:"dry_run"`
+		WatchError string      `json:"watch_error,omitempty"`
+		Jobs       []*agentJob `json:"jobs"`
+	}{
+		Prefix:     a.prefix,
+		DryRun:     a.dryRun,
+		WatchError: a.watchError,
+		Jobs:       make([]*agentJob, 0, len(a.jobs)),
+	}
+	for _, job := range a.jobs {
+		j := *job
+		status.Jobs = append(status.Jobs, &j)
+	}
+	a.mu.Unlock()
+
+	sort.Slice(status.Jobs, func(i, j int) bool { return status.Jobs[i].Key < status.Jobs[j].Key })
+
+	w.Header().Set("Content-Type", "application/json")
+	if status.WatchError != "" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(status)
+}
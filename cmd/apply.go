@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bdclark/nomadctl/deploy"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var applyCmd = &cobra.Command{
+	Use:   "apply MANIFEST",
+	Short: "Deploy the jobs listed in a manifest",
+	Long: `Renders, plans and deploys every job listed in a manifest file, in
+the order required by their dependencies.
+
+The manifest can be written in YAML, HCL, JSON or TOML, determined by its
+file extension. Each job is either a Consul JOBKEY (see "nomadctl help
+deploy kv") or a template source (see "nomadctl help deploy template"),
+relative to the manifest's directory if a local file. For example:
+
+parallel: 4
+jobs:
+  - key: myapp/db
+  - name: web
+    source: ./jobs/web.nomad
+    depends_on: ["myapp/db"]
+    deploy:
+      auto_promote: true
+      timeout: 10m
+
+A job's name defaults to its key or source and is used for "depends_on".
+The optional "deploy" settings of a job ("auto_promote", "auto_revert",
+"canary_analysis", "canary_soak", "force_count", "on_failure", "promote_order", "promote_pause"
+and "timeout") override config file, environment variable
+and Consul settings, but not command-line flags. A job given by key whose
+deployment requires approval ("deploy.require_approval", see "nomadctl
+help deploy kv") waits for "nomadctl approve", and this cannot be turned
+off in the manifest.
+
+Every job is rendered and planned before any job is deployed, then once
+confirmed (unless the "yes" flag is set), the jobs are deployed in
+dependency order, up to "parallel" jobs at once. Each job is only
+registered if the remote job has not changed since it was planned. If a
+job's deployment fails, the jobs that depend on it are skipped. A summary
+of the results is displayed once all jobs are complete, and a non-zero
+exit code is returned if any job failed or was skipped.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := initConfig(cmd); err != nil {
+			bail(err, 1)
+		}
+
+		m, err := loadManifest(args[0])
+		if err != nil {
+			bail(err, 1)
+		}
+
+		parallel := m.Parallel
+		if f := cmd.Flags().Lookup("parallel"); f.Changed || parallel == 0 {
+			parallel, _ = cmd.Flags().GetInt("parallel")
+		}
+
+		jobs, _, err := renderBatchJobs(cmd, m.jobSpecs(filepath.Dir(args[0])), false)
+		if err != nil {
+			bail(err, 1)
+		}
+
+		batch, err := deploy.NewBatch(jobs)
+		if err != nil {
+			bail(err, 1)
+		}
+
+		// plan every job before deploying any of them
+		changes, err := planBatchJobs(batch, jobs)
+		if err != nil {
+			bail(err, 1)
+		}
+
+		if changes && !viper.GetBool("deploy.skip_confirmation") {
+			if confirm := askForConfirmation("Changes found, continue deployment?"); !confirm {
+				fmt.Fprintln(os.Stderr, "Abandoning deployment.")
+				os.Exit(0)
+			}
+		}
+
+		results := batch.Run(interruptContext(cmd), &deploy.BatchRunInput{Parallel: parallel})
+		deploy.PrintBatchResults(results)
+
+		for _, r := range results {
+			if !r.Success {
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(applyCmd)
+
+	addConfigFlags(applyCmd)
+	addConsulFlags(applyCmd)
+	addMonitorFlags(applyCmd)
+	applyCmd.Flags().Int("parallel", 1, "number of jobs to deploy at once (overrides manifest)")
+	applyCmd.Flags().Bool("auto-promote", false, "automatically promote canary deployments")
+	applyCmd.Flags().Bool("force-count", false, "force task group counts to match templates")
+	applyCmd.Flags().Bool("yes", false, "skips asking for confirmation if plan changes found")
+}
+
+// manifest is the list of jobs deployed by the apply command
+type manifest struct {
+	Parallel int            `mapstructure:"parallel"`
+	Jobs     []*manifestJob `mapstructure:"jobs"`
+}
+
+// manifestJob is a job within a manifest
+type manifestJob struct {
+	Name      string                 `mapstructure:"name"`
+	Key       string                 `mapstructure:"key"`
+	Source    string                 `mapstructure:"source"`
+	DependsOn []string               `mapstructure:"depends_on"`
+	Deploy    map[string]interface{} `mapstructure:"deploy"`
+}
+
+// loadManifest reads and validates a manifest file
+func loadManifest(path string) (*manifest, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, errors.Wrapf(err, "failed to read manifest \"%s\"", path)
+	}
+
+	var m manifest
+	if err := v.Unmarshal(&m); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse manifest \"%s\"", path)
+	}
+
+	if len(m.Jobs) == 0 {
+		return nil, fmt.Errorf("manifest \"%s\" has no jobs", path)
+	}
+
+	for i, j := range m.Jobs {
+		if (j.Key == "") == (j.Source == "") {
+			return nil, fmt.Errorf("manifest job %d must have exactly one of \"key\" or \"source\"", i+1)
+		}
+		if j.Name == "" {
+			j.Name = j.Key + j.Source
+		}
+		for k := range j.Deploy {
+			if _, ok := batchDeployFlags[k]; !ok {
+				return nil, fmt.Errorf("manifest job \"%s\" has unsupported deploy setting \"%s\"", j.Name, k)
+			}
+		}
+	}
+
+	return &m, nil
+}
+
+// jobSpecs returns the spec of each job in the manifest, with local
+// template sources relative to the manifest's directory
+func (m *manifest) jobSpecs(dir string) []*batchJobSpec {
+	specs := make([]*batchJobSpec, 0, len(m.Jobs))
+
+	for _, j := range m.Jobs {
+		spec := &batchJobSpec{
+			name:      j.Name,
+			key:       j.Key,
+			deploy:    j.Deploy,
+			dependsOn: j.DependsOn,
+		}
+
+		if j.Source != "" {
+			source := j.Source
+			if !filepath.IsAbs(source) {
+				if _, err := os.Stat(filepath.Join(dir, source)); err == nil {
+					source = filepath.Join(dir, source)
+				}
+			}
+			spec.settings = map[string]interface{}{"template.source": source}
+		}
+
+		specs = append(specs, spec)
+	}
+
+	return specs
+}
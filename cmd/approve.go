@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+
+	"github.com/bdclark/nomadctl/deploy"
+	"github.com/spf13/cobra"
+)
+
+var approveCmd = &cobra.Command{
+	Use:   "approve JOBKEY [DEPLOYMENT_ID]",
+	Short: "Approve promotion of a deployment's canaries",
+	Long: `Approves the promotion of the canaries of a deployment awaiting
+approval, such as one deployed with the "require-approval" flag or
+"deploy.require_approval" setting (see "nomadctl help deploy kv").
+
+The required JOBKEY argument is the Consul KV path of the job, combined
+with the "prefix" if set. The pending approval is read from
+"${JOBKEY}/deploy/approvals/${DEPLOYMENT_ID}". If more than one deployment
+of the job awaits approval, the DEPLOYMENT_ID (or unique ID prefix) is
+required.
+
+Who approved the deployment (the "by" flag, "user@host" by default), when,
+and the optional "message" are recorded with the approval and logged by
+the waiting deployment, which then promotes its canaries.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		decideApproval(cmd, args, true)
+	},
+}
+
+var rejectCmd = &cobra.Command{
+	Use:   "reject JOBKEY [DEPLOYMENT_ID]",
+	Short: "Reject promotion of a deployment's canaries",
+	Long: `Rejects the promotion of the canaries of a deployment awaiting
+approval, such as one deployed with the "require-approval" flag or
+"deploy.require_approval" setting (see "nomadctl help deploy kv").
+
+The required JOBKEY argument is the Consul KV path of the job, combined
+with the "prefix" if set. The pending approval is read from
+"${JOBKEY}/deploy/approvals/${DEPLOYMENT_ID}". If more than one deployment
+of the job awaits approval, the DEPLOYMENT_ID (or unique ID prefix) is
+required.
+
+Who rejected the deployment (the "by" flag, "user@host" by default), when,
+and the optional "message" are recorded with the rejection. The waiting
+deployment then fails the Nomad deployment instead of promoting it (and
+takes its "on-failure" action).`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		decideApproval(cmd, args, false)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(approveCmd)
+	rootCmd.AddCommand(rejectCmd)
+
+	for _, cmd := range []*cobra.Command{approveCmd, rejectCmd} {
+		addConfigFlags(cmd)
+		addConsulFlags(cmd)
+		cmd.Flags().String("by", "", "who is deciding, recorded with the decision (default \"user@host\")")
+		cmd.Flags().String("message", "", "the reason for the decision, recorded with it")
+	}
+}
+
+// decideApproval approves or rejects the deployment awaiting approval
+// under the job key given as an argument
+func decideApproval(cmd *cobra.Command, args []string, approve bool) {
+	if err := initConfig(cmd); err != nil {
+		bail(err, 1)
+	}
+
+	by, _ := cmd.Flags().GetString("by")
+	if by == "" {
+		by = approver()
+	}
+	message, _ := cmd.Flags().GetString("message")
+
+	var id string
+	if len(args) == 2 {
+		id = args[1]
+	}
+
+	client, err := consulClient()
+	if err != nil {
+		bail(err, 1)
+	}
+	nomad, err := nomadClient()
+	if err != nil {
+		bail(err, 1)
+	}
+
+	a, err := deploy.DecideApproval(&deploy.DecideApprovalInput{
+		Client:       client,
+		NomadClient:  nomad,
+		JobKey:       canonicalizeJobKey(args[0]),
+		DeploymentID: id,
+		Approve:      approve,
+		By:           by,
+		Message:      message,
+	})
+	if err != nil {
+		bail(err, 1)
+	}
+
+	fmt.Fprintf(os.Stderr, "Deployment \"%s\" of job \"%s\" %s by %s.\n", a.DeploymentID, a.JobID, a.Status, a.DecidedBy)
+}
+
+// approver returns who is approving or rejecting a deployment if not
+// given, as "user@host"
+func approver() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		name += "@" + host
+	}
+	return name
+}
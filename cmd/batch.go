@@ -0,0 +1,277 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/bdclark/nomadctl/deploy"
+	"github.com/bdclark/nomadctl/logging"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// batchJobSpec describes how to render a job that is part of a batch
+type batchJobSpec struct {
+	name      string                 // unique name of the job within the batch
+	key       string                 // Consul job key, if the job is configured in Consul
+	settings  map[string]interface{} // config set before the job is rendered
+	deploy    map[string]interface{} // deploy settings set after the job is rendered, unless their flag is set
+	dependsOn []string               // names of the jobs that must be deployed first
+	logger    *logging.Logger        // logs the job's deployment, prefixed with its name if nil
+	context   string                 // config context to render and deploy the job with, if not the current context
+}
+
+// batchDeployFlags maps the deploy settings that can be set
+// per job within a batch to the flags that override them
+var batchDeployFlags = map[string]string{
+	"auto_promote":    "auto-promote",
+	"auto_revert":     "auto-revert",
+	"canary_analysis": "canary-analysis",
+	"canary_soak":     "canary-soak",
+	"force_count":     "force-count",
+	"on_failure":      "on-failure",
+	"promote_order":   "promote-order",
+	"promote_pause":   "promote-pause",
+	"timeout":         "timeout",
+}
+
+// addBatchFlags adds flags related to deploying or planning
+// every job under a prefix to the given command
+func addBatchFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("all", false, "every job under PREFIX (or the configured prefix)")
+	cmd.Flags().Int("parallel", 1, "number of jobs to run at once with --all")
+	cmd.Flags().String("match", "", "only jobs with keys matching this glob with --all")
+	cmd.Flags().Bool("continue-on-error", false, "keep starting jobs after a job fails with --all")
+}
+
+// batchArgs accepts an optional PREFIX argument if the "all" flag is set,
+// otherwise exactly one JOBKEY argument
+func batchArgs(cmd *cobra.Command, args []string) error {
+	if all, _ := cmd.Flags().GetBool("all"); all {
+		return cobra.MaximumNArgs(1)(cmd, args)
+	}
+	return cobra.ExactArgs(1)(cmd, args)
+}
+
+// allJobSpecs returns a spec for each job under the prefix given as an
+// argument (or the configured prefix) whose key matches the "match" flag
+func allJobSpecs(cmd *cobra.Command, args []string) ([]*batchJobSpec, error) {
+	prefix, err := jobPrefix(args)
+	if err != nil {
+		return nil, err
+	}
+
+	match, _ := cmd.Flags().GetString("match")
+	if _, err := path.Match(match, ""); err != nil {
+		return nil, fmt.Errorf("invalid match pattern \"%s\"", match)
+	}
+
+	client, err := consulClient()
+	if err != nil {
+		return nil, err
+	}
+	list, _, err := client.KV().List(prefix+"/", nil)
+	if err != nil {
+		return nil, err
+	}
+	kvMap, err := explode(&list, prefix+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(kvMap))
+	for k := range kvMap {
+		if k == deploy.RecordsKey {
+			continue
+		}
+		if ok, _ := path.Match(match, k); match == "" || ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no jobs found under prefix \"%s\"", prefix)
+	}
+
+	specs := make([]*batchJobSpec, 0, len(keys))
+	for _, k := range keys {
+		specs = append(specs, &batchJobSpec{
+			name:     k,
+			key:      k,
+			settings: map[string]interface{}{"prefix": prefix},
+		})
+	}
+	return specs, nil
+}
+
+// jobPrefix returns the prefix given as an argument, or the configured prefix
+func jobPrefix(args []string) (string, error) {
+	prefix := viper.GetString("prefix")
+	if len(args) == 1 && args[0] != "" {
+		prefix = args[0]
+	}
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return "", fmt.Errorf("a prefix is required, either as an argument or configured")
+	}
+	return prefix, nil
+}
+
+// renderBatchJobs renders each job of a batch, one at a time since the
+// config is reset for each job, and returns them ready to be deployed.
+// If continueOnError is set, a failed result is returned for each job
+// that cannot be rendered, otherwise an error is returned. The config
+// of the command itself is restored once all jobs are rendered.
+func renderBatchJobs(cmd *cobra.Command, specs []*batchJobSpec, continueOnError bool) ([]*deploy.BatchJob, []*deploy.BatchResult, error) {
+	var jobs []*deploy.BatchJob
+	var failed []*deploy.BatchResult
+
+	detach, _ := cmd.Flags().GetBool("detach")
+
+	for _, spec := range specs {
+		job, err := renderBatchJob(cmd, spec, detach)
+		if err != nil {
+			if !continueOnError {
+				return nil, nil, err
+			}
+			fmt.Fprintln(os.Stderr, err)
+			failed = append(failed, &deploy.BatchResult{Name: spec.name, Err: err})
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+
+	viper.Reset()
+	if err := initConfig(cmd); err != nil {
+		return nil, nil, err
+	}
+
+	return jobs, failed, nil
+}
+
+// renderBatchJob renders a single job of a batch with its own config
+func renderBatchJob(cmd *cobra.Command, spec *batchJobSpec, detach bool) (*deploy.BatchJob, error) {
+	jobspec, err := renderSpec(cmd, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	// job-specific settings override everything but flags
+	for key, value := range spec.deploy {
+		if f := cmd.Flags().Lookup(batchDeployFlags[key]); f != nil && f.Changed {
+			continue
+		}
+		viper.Set("deploy."+key, value)
+	}
+
+	onFailure, err := failureAction()
+	if err != nil {
+		return nil, errors.Wrapf(err, "job \"%s\"", spec.name)
+	}
+	order, err := promoteOrder()
+	if err != nil {
+		return nil, errors.Wrapf(err, "job \"%s\"", spec.name)
+	}
+	approvals, err := approvalKey(spec.key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "job \"%s\"", spec.name)
+	}
+
+	client, err := nomadClient()
+	if err != nil {
+		return nil, err
+	}
+	monitorClient, err := monitorConsulClient(spec.key)
+	if err != nil {
+		return nil, err
+	}
+
+	deployment, err := deploy.NewDeployment(&deploy.NewDeploymentInput{
+		Client:           client,
+		AutoPromote:      viper.GetBool("deploy.auto_promote"),
+		CanarySoak:       viper.GetDuration("deploy.canary_soak"),
+		ConsulClient:     monitorClient,
+		PromoteOrder:     order,
+		PromotePause:     viper.GetDuration("deploy.promote_pause"),
+		CanaryAnalysis:   canaryAnalysis(),
+		ApprovalKey:      approvals,
+		UseTemplateCount: viper.GetBool("deploy.force_count"),
+		Timeout:          viper.GetDuration("deploy.timeout"),
+		AutoRevert:       viper.GetBool("deploy.auto_revert"),
+		OnFailure:        onFailure,
+		Detach:           detach,
+		LogPrefix:        spec.name,
+		Logger:           spec.logger,
+		Verbose:          false,
+		Jobspec:          &jobspec,
+		JobKey:           deployedJobKey(spec.key),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "job \"%s\"", spec.name)
+	}
+
+	return &deploy.BatchJob{
+		Name:       spec.name,
+		DependsOn:  spec.dependsOn,
+		Deployment: deployment,
+	}, nil
+}
+
+// renderSpec resets the config to that of the command, applies the
+// spec's settings and renders its job
+func renderSpec(cmd *cobra.Command, spec *batchJobSpec) ([]byte, error) {
+	viper.Reset()
+	if spec.context != "" {
+		if err := loadConfig(cmd); err != nil {
+			return nil, err
+		}
+		if err := applyContext(spec.context); err != nil {
+			return nil, err
+		}
+	} else if err := initConfig(cmd); err != nil {
+		return nil, err
+	}
+
+	for k, v := range spec.settings {
+		viper.Set(k, v)
+	}
+
+	jobspec, err := renderJob(cmd, spec.key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to render job \"%s\"", spec.name)
+	}
+	return jobspec, nil
+}
+
+// planBatchJobs plans each job of a batch in dependency order, and returns
+// whether any allocations will be created/destroyed. Jobs are then only
+// registered if the remote job has not changed since it was planned.
+func planBatchJobs(batch *deploy.Batch, jobs []*deploy.BatchJob) (bool, error) {
+	deployments := make(map[string]*deploy.Deployment)
+	for _, j := range jobs {
+		deployments[j.Name] = j.Deployment
+	}
+
+	order, err := batch.Order()
+	if err != nil {
+		return false, err
+	}
+
+	changes := false
+	for _, name := range order {
+		fmt.Printf("==> Job \"%s\"\n", name)
+		c, err := deployments[name].Plan(&deploy.PlanInput{Diff: true})
+		if err != nil {
+			return false, errors.Wrapf(err, "failed to plan job \"%s\"", name)
+		}
+		fmt.Println()
+		changes = changes || c
+		deployments[name].EnforcePlanIndex()
+	}
+	return changes, nil
+}
@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bdclark/nomadctl/deploy"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// clusterResult is the result of deploying a job to one cluster
+type clusterResult struct {
+	*deploy.BatchResult
+	wave int // the wave the cluster was deployed in, starting at 1
+}
+
+// clusterWaves returns the waves of clusters (config contexts) a job is
+// deployed to, from the "clusters" flag or the "deploy.clusters" setting.
+// Each entry is a wave of one or more clusters joined with "+".
+func clusterWaves(cmd *cobra.Command) ([][]string, error) {
+	entries := viper.GetStringSlice("deploy.clusters")
+	if f := cmd.Flags().Lookup("clusters"); f != nil && f.Changed {
+		entries, _ = cmd.Flags().GetStringSlice("clusters")
+	}
+
+	var waves [][]string
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		var wave []string
+		for _, name := range strings.Split(entry, "+") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if seen[strings.ToLower(name)] {
+				return nil, fmt.Errorf("cluster \"%s\" listed more than once", name)
+			}
+			seen[strings.ToLower(name)] = true
+			if _, err := contextSettings(name); err != nil {
+				return nil, err
+			}
+			wave = append(wave, name)
+		}
+		if len(wave) > 0 {
+			waves = append(waves, wave)
+		}
+	}
+	return waves, nil
+}
+
+// doDeployClusters renders a job with the settings of each cluster, then
+// deploys it to each wave of clusters in turn
+func doDeployClusters(cmd *cobra.Command, jobKey string, waves [][]string) {
+	var specs []*batchJobSpec
+	for _, wave := range waves {
+		for _, cluster := range wave {
+			specs = append(specs, &batchJobSpec{name: cluster, key: jobKey, context: cluster})
+		}
+	}
+
+	// render the job for every cluster before deploying to any
+	jobs, _, err := renderBatchJobs(cmd, specs, false)
+	if err != nil {
+		bail(err, 1)
+	}
+	byCluster := make(map[string]*deploy.BatchJob)
+	for _, j := range jobs {
+		byCluster[j.Name] = j
+	}
+
+	// run a plan for every cluster first if specified
+	if viper.GetBool("deploy.plan") {
+		changes := false
+		for _, j := range jobs {
+			fmt.Printf("==> Cluster \"%s\"\n", j.Name)
+			c, err := j.Deployment.Plan(&deploy.PlanInput{Diff: true})
+			if err != nil {
+				bail(errors.Wrapf(err, "failed to plan job for cluster \"%s\"", j.Name), 1)
+			}
+			fmt.Println()
+			changes = changes || c
+			j.Deployment.EnforcePlanIndex()
+		}
+
+		if changes && !viper.GetBool("deploy.skip_confirmation") {
+			if confirm := askForConfirmation("Changes found, continue deployment?"); !confirm {
+				fmt.Fprintln(os.Stderr, "Abandoning deployment.")
+				os.Exit(0)
+			}
+		}
+	}
+
+	continueOnError, _ := cmd.Flags().GetBool("continue-on-error")
+	interactive := terminal.IsTerminal(int(os.Stdin.Fd())) && !viper.GetBool("deploy.skip_confirmation")
+	ctx := interruptContext(cmd)
+
+	var results []*clusterResult
+	halted := false
+	for i, wave := range waves {
+		if !halted && i > 0 && viper.GetBool("deploy.wave_approval") {
+			halted = !askForConfirmation(fmt.Sprintf("Deploy wave %d (%s)?", i+1, strings.Join(wave, ", ")))
+		}
+		if halted || ctx.Err() != nil {
+			for _, cluster := range wave {
+				results = append(results, &clusterResult{&deploy.BatchResult{Name: cluster, Skipped: true}, i + 1})
+			}
+			continue
+		}
+
+		waveJobs := make([]*deploy.BatchJob, 0, len(wave))
+		for _, cluster := range wave {
+			waveJobs = append(waveJobs, byCluster[cluster])
+		}
+		batch, err := deploy.NewBatch(waveJobs)
+		if err != nil {
+			bail(err, 1)
+		}
+
+		var failed []string
+		for _, r := range batch.Run(ctx, &deploy.BatchRunInput{Parallel: len(waveJobs)}) {
+			results = append(results, &clusterResult{r, i + 1})
+			if !r.Success {
+				failed = append(failed, r.Name)
+			}
+		}
+
+		// halt later waves after a failure, unless told otherwise
+		if len(failed) > 0 && i < len(waves)-1 && !continueOnError {
+			msg := fmt.Sprintf("Deployment failed for %s, continue with wave %d?", strings.Join(failed, ", "), i+2)
+			halted = !interactive || !askForConfirmation(msg)
+		}
+	}
+
+	printClusterResults(results)
+
+	for _, r := range results {
+		if !r.Success {
+			os.Exit(1)
+		}
+	}
+}
+
+// printClusterResults prints a summary table of a job's deployment to each cluster
+func printClusterResults(results []*clusterResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Wave\tCluster\tResult\tDeployment ID\tDuration")
+	for _, r := range results {
+		result := "success"
+		switch {
+		case r.Skipped:
+			result = "skipped"
+		case !r.Success:
+			result = "failed"
+		}
+		id := r.DeploymentID
+		if len(id) > 8 {
+			id = id[:8]
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", r.wave, r.Name, result, id, r.Duration.Round(time.Second))
+	}
+	w.Flush()
+}
@@ -1,15 +1,18 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
-	"os"
+	"sort"
 	"strings"
 
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/viper"
 
 	"github.com/bdclark/nomadctl/logging"
+	"github.com/bdclark/nomadctl/ops"
 	consul "github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
@@ -18,13 +21,50 @@ func addConfigFlags(cmd *cobra.Command) {
 	cmd.Flags().String("config", "", "config file to use (default is $HOME/.nomadctl.yaml)")
 }
 
-func initConfig(cmd *cobra.Command) {
+// addClientFlags adds Nomad and Consul client flags to the given command
+// and its sub-commands
+func addClientFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().String("address", "", "address of the Nomad server")
+	cmd.PersistentFlags().String("region", "", "region of the Nomad server to forward commands to")
+	cmd.PersistentFlags().String("namespace", "", "Nomad namespace to use")
+	cmd.PersistentFlags().String("token", "", "Nomad ACL token")
+	cmd.PersistentFlags().String("ca-cert", "", "path to a CA cert to verify the Nomad server cert")
+	cmd.PersistentFlags().String("ca-path", "", "path to a directory of CA certs to verify the Nomad server cert")
+	cmd.PersistentFlags().String("client-cert", "", "path to a client cert for TLS authentication to Nomad")
+	cmd.PersistentFlags().String("client-key", "", "path to the private key matching the Nomad client cert")
+	cmd.PersistentFlags().String("tls-server-name", "", "server name to use as the SNI host for Nomad")
+	cmd.PersistentFlags().Bool("tls-skip-verify", false, "do not verify the Nomad server cert (not recommended)")
+
+	cmd.PersistentFlags().String("consul-address", "", "address of the Consul agent")
+	cmd.PersistentFlags().String("consul-datacenter", "", "Consul datacenter to use")
+	cmd.PersistentFlags().String("consul-token", "", "Consul ACL token")
+	cmd.PersistentFlags().String("consul-ca-cert", "", "path to a CA cert to verify the Consul agent cert")
+	cmd.PersistentFlags().String("consul-ca-path", "", "path to a directory of CA certs to verify the Consul agent cert")
+	cmd.PersistentFlags().String("consul-client-cert", "", "path to a client cert for TLS authentication to Consul")
+	cmd.PersistentFlags().String("consul-client-key", "", "path to the private key matching the Consul client cert")
+	cmd.PersistentFlags().String("consul-tls-server-name", "", "server name to use as the SNI host for Consul")
+	cmd.PersistentFlags().Bool("consul-tls-skip-verify", false, "do not verify the Consul agent cert (not recommended)")
+}
+
+// initConfig loads the config for a command, including the settings of
+// the current context, if any. An error is returned rather than exiting,
+// since the server and agent load the config for every job they render.
+func initConfig(cmd *cobra.Command) error {
+	if err := loadConfig(cmd); err != nil {
+		return err
+	}
+	return useContext(cmd)
+}
+
+// loadConfig loads the config for a command, without the settings of any context
+func loadConfig(cmd *cobra.Command) error {
 	// setup logging level
 	if level, _ := cmd.Flags().GetString("log-level"); level != "" {
 		logging.SetLevel(level)
 	}
 
 	// set configuration defaults
+	viper.SetDefault("context", "")
 	viper.SetDefault("prefix", "")
 	viper.SetDefault("template", map[string]interface{}{
 		"left_delimiter":       "{{",
@@ -35,31 +75,110 @@ func initConfig(cmd *cobra.Command) {
 		"options":              make(map[string]interface{}),
 	})
 	viper.SetDefault("deploy", map[string]interface{}{
-		"auto_promote":      false,
-		"force_count":       false,
-		"plan":              false,
-		"skip_confirmation": false,
+		"analysis_max_cpu_increase":    50,
+		"analysis_max_failure_events":  0,
+		"analysis_max_memory_increase": 50,
+		"analysis_max_restarts":        0,
+		"auto_promote":                 false,
+		"auto_revert":                  false,
+		"canary_analysis":              false,
+		"canary_soak":                  0,
+		"clusters":                     []string{},
+		"force_count":                  false,
+		"on_failure":                   "none",
+		"on_interrupt":                 "detach",
+		"plan":                         false,
+		"promote_order":                []string{},
+		"promote_pause":                0,
+		"require_approval":             false,
+		"skip_confirmation":            false,
+		"timeout":                      0,
+		"wave_approval":                false,
 	})
 	viper.SetDefault("plan", map[string]interface{}{
-		"no_color": false,
-		"diff":     true,
-		"quiet":    false,
-		"verbose":  false,
+		"no_color":    false,
+		"diff":        true,
+		"format":      "text",
+		"policy_file": "",
+		"quiet":       false,
+		"verbose":     false,
+	})
+	viper.SetDefault("server", map[string]interface{}{
+		"token": "",
+	})
+	viper.SetDefault("nomad", map[string]interface{}{
+		"address":         "",
+		"region":          "",
+		"namespace":       "",
+		"token":           "",
+		"token_env":       "",
+		"token_file":      "",
+		"ca_cert":         "",
+		"ca_path":         "",
+		"client_cert":     "",
+		"client_key":      "",
+		"tls_server_name": "",
+		"tls_skip_verify": false,
+	})
+	viper.SetDefault("consul", map[string]interface{}{
+		"address":         "",
+		"datacenter":      "",
+		"token":           "",
+		"token_env":       "",
+		"token_file":      "",
+		"ca_cert":         "",
+		"ca_path":         "",
+		"client_cert":     "",
+		"client_key":      "",
+		"tls_server_name": "",
+		"tls_skip_verify": false,
 	})
 
 	// bind viper to command-line flags
+	bindFlag(cmd, "context", "context")
 	bindFlag(cmd, "prefix", "prefix")
 	bindFlag(cmd, "template.left_delimiter", "left-delim")
 	bindFlag(cmd, "template.right_delimiter", "right-delim")
 	bindFlag(cmd, "template.error_on_missing_key", "err-missing-key")
 	bindFlag(cmd, "deploy.auto_promote", "auto-promote")
+	bindFlag(cmd, "deploy.auto_revert", "auto-revert")
+	bindFlag(cmd, "deploy.canary_analysis", "canary-analysis")
+	bindFlag(cmd, "deploy.canary_soak", "canary-soak")
 	bindFlag(cmd, "deploy.force_count", "force-count")
+	bindFlag(cmd, "deploy.on_failure", "on-failure")
+	bindFlag(cmd, "deploy.on_interrupt", "on-interrupt")
 	bindFlag(cmd, "deploy.plan", "plan")
+	bindFlag(cmd, "deploy.promote_order", "promote-order")
+	bindFlag(cmd, "deploy.promote_pause", "promote-pause")
+	bindFlag(cmd, "deploy.require_approval", "require-approval")
 	bindFlag(cmd, "deploy.skip_confirmation", "yes")
+	bindFlag(cmd, "deploy.timeout", "timeout")
+	bindFlag(cmd, "deploy.wave_approval", "wave-approval")
 	bindFlag(cmd, "plan.no_color", "no-color")
 	bindFlag(cmd, "plan.diff", "diff")
+	bindFlag(cmd, "plan.format", "format")
+	bindFlag(cmd, "plan.policy_file", "policy")
 	bindFlag(cmd, "plan.quiet", "quiet")
 	bindFlag(cmd, "plan.verbose", "verbose")
+	bindFlag(cmd, "nomad.address", "address")
+	bindFlag(cmd, "nomad.region", "region")
+	bindFlag(cmd, "nomad.namespace", "namespace")
+	bindFlag(cmd, "nomad.token", "token")
+	bindFlag(cmd, "nomad.ca_cert", "ca-cert")
+	bindFlag(cmd, "nomad.ca_path", "ca-path")
+	bindFlag(cmd, "nomad.client_cert", "client-cert")
+	bindFlag(cmd, "nomad.client_key", "client-key")
+	bindFlag(cmd, "nomad.tls_server_name", "tls-server-name")
+	bindFlag(cmd, "nomad.tls_skip_verify", "tls-skip-verify")
+	bindFlag(cmd, "consul.address", "consul-address")
+	bindFlag(cmd, "consul.datacenter", "consul-datacenter")
+	bindFlag(cmd, "consul.token", "consul-token")
+	bindFlag(cmd, "consul.ca_cert", "consul-ca-cert")
+	bindFlag(cmd, "consul.ca_path", "consul-ca-path")
+	bindFlag(cmd, "consul.client_cert", "consul-client-cert")
+	bindFlag(cmd, "consul.client_key", "consul-client-key")
+	bindFlag(cmd, "consul.tls_server_name", "consul-tls-server-name")
+	bindFlag(cmd, "consul.tls_skip_verify", "consul-tls-skip-verify")
 
 	// bind viper to environment variables
 	viper.SetEnvPrefix("nomadctl")
@@ -74,8 +193,7 @@ func initConfig(cmd *cobra.Command) {
 	} else {
 		home, err := homedir.Dir()
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			return errors.Wrap(err, "failed to find home directory")
 		}
 		viper.AddConfigPath(home)
 		viper.SetConfigName(".nomadctl")
@@ -86,6 +204,7 @@ func initConfig(cmd *cobra.Command) {
 	} else {
 		logging.Debug("failed to read config file \"%s\": %v", viper.ConfigFileUsed(), err)
 	}
+	return nil
 }
 
 // addConsulFlags adds consul related flags the given command
@@ -108,16 +227,35 @@ func addDeployFlags(cmd *cobra.Command) {
 	cmd.Flags().Bool("force-count", false, "force task group counts to match template")
 	cmd.Flags().Bool("plan", false, "run job plan before deploying")
 	cmd.Flags().Bool("yes", false, "skips asking for confirmation if plan changes found")
+	cmd.Flags().Bool("detach", false, "print evaluation and deployment IDs as JSON and exit without monitoring")
+	cmd.Flags().Bool("json", false, "print the result of the monitored deployment as JSON, including any canary analysis")
+	addMonitorFlags(cmd)
+}
+
+// addMonitorFlags adds flags related to monitoring a deployment to the given command
+func addMonitorFlags(cmd *cobra.Command) {
+	cmd.Flags().Duration("timeout", 0, "fail the deployment if not complete within this duration (0 waits forever)")
+	cmd.Flags().Bool("auto-revert", false, "revert job to last stable version if deployment times out")
+	cmd.Flags().Bool("canary-analysis", false, "compare healthy canaries against stable allocations before auto-promoting them")
+	cmd.Flags().Duration("canary-soak", 0, "watch healthy canaries for this duration before auto-promoting them")
+	cmd.Flags().StringSlice("promote-order", []string{}, "auto-promote task groups in this order, join groups with \"+\" to promote them in the same wave")
+	cmd.Flags().Duration("promote-pause", 0, "wait this long between promoting waves of task groups")
+	cmd.Flags().String("on-failure", "none", "action when the deployment fails: none, revert or revert-and-wait")
+	cmd.Flags().String("on-interrupt", "detach", "action when interrupted and not interactive: detach, fail or revert")
 }
 
 // addPlanFlags adds plan related flags to the given command
 func addPlanFlags(cmd *cobra.Command) {
 	addConfigFlags(cmd)
 	addTemplateFlags(cmd)
+	cmd.Flags().Bool("force-count", false, "force task group counts to match template")
 	cmd.Flags().Bool("no-color", false, "disable colorized output")
 	cmd.Flags().Bool("diff", true, "show diff between remote job and planned job")
 	cmd.Flags().Bool("quiet", false, "no plan output (just return status)")
 	cmd.Flags().Bool("verbose", false, "verbose plan output")
+	cmd.Flags().String("format", "text", "plan output format: text, json or markdown")
+	cmd.Flags().String("policy", "", "plan policy file to check the plan against")
+	cmd.Flags().String("out", "", "save the plan to a file for \"deploy --plan-file\"")
 }
 
 // setConfigFromKV sets viper keys based on values in Consul, but only sets
@@ -127,19 +265,19 @@ func setConfigFromKV(cmd *cobra.Command, client *consul.Client, jobKey string) e
 		logging.Warning("supplied job key \"%s\" contains configured prefix \"%s\", was this your intent?", jobKey, p)
 	}
 
-	prefix := canonicalizeJobKey(jobKey) + "/"
-
-	pairs, _, err := client.KV().List(prefix, nil)
+	values, err := ops.ReadJobKey(context.Background(), client, canonicalizeJobKey(jobKey))
 	if err != nil {
 		return err
 	}
 
-	for _, pair := range pairs {
-		key := pair.Key[len(prefix):]
-		value := string(pair.Value)
-		if value == "" {
-			continue
-		}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := values[key]
 
 		switch key {
 		case "template/source":
@@ -152,8 +290,29 @@ func setConfigFromKV(cmd *cobra.Command, client *consul.Client, jobKey string) e
 			setConfigFromKVHelper(cmd, "err-missing-key", key, value)
 		case "deploy/auto_promote":
 			setConfigFromKVHelper(cmd, "auto-promote", key, value)
+		case "deploy/auto_revert":
+			setConfigFromKVHelper(cmd, "auto-revert", key, value)
+		case "deploy/canary_analysis":
+			setConfigFromKVHelper(cmd, "canary-analysis", key, value)
+		case "deploy/analysis_max_restarts", "deploy/analysis_max_failure_events",
+			"deploy/analysis_max_cpu_increase", "deploy/analysis_max_memory_increase":
+			setConfigFromKVHelper(cmd, "", key, value)
+		case "deploy/canary_soak":
+			setConfigFromKVHelper(cmd, "canary-soak", key, value)
 		case "deploy/force_count":
 			setConfigFromKVHelper(cmd, "force-count", key, value)
+		case "deploy/on_failure":
+			setConfigFromKVHelper(cmd, "on-failure", key, value)
+		case "deploy/promote_order":
+			setConfigFromKVHelper(cmd, "promote-order", key, value)
+		case "deploy/promote_pause":
+			setConfigFromKVHelper(cmd, "promote-pause", key, value)
+		case "deploy/require_approval":
+			setConfigFromKVHelper(cmd, "require-approval", key, value)
+		case "deploy/timeout":
+			setConfigFromKVHelper(cmd, "timeout", key, value)
+		case "plan/policy":
+			setConfigFromKVHelper(cmd, "policy", key, value)
 		}
 
 		// getter options
@@ -165,9 +324,7 @@ func setConfigFromKV(cmd *cobra.Command, client *consul.Client, jobKey string) e
 	}
 
 	// parsing template option flags here so they will override consul settings
-	parseTemplateOptionFlags(cmd)
-
-	return nil
+	return parseTemplateOptionFlags(cmd)
 }
 
 // setsetConfigFromKVHelper is used by the setConfigFromKV function, and sets a
@@ -206,17 +363,26 @@ func canonicalizeJobKey(jobKey string) string {
 	}
 }
 
+// deployedJobKey returns the full job key recorded in Consul for a job
+// deployed from the given job key, or an empty string if there is none
+func deployedJobKey(jobKey string) string {
+	if jobKey == "" {
+		return ""
+	}
+	return canonicalizeJobKey(jobKey)
+}
+
 // parseTemplateOptionFlags loops through "option" flag(s) provided
 // and sets (overrides) them in the templation options map
-func parseTemplateOptionFlags(cmd *cobra.Command) {
+func parseTemplateOptionFlags(cmd *cobra.Command) error {
 	if cmd.Flags().Lookup("option") == nil {
-		return
+		return nil
 	}
 
 	options, _ := cmd.Flags().GetStringSlice("option")
 	for _, option := range options {
 		if !strings.Contains(option, "=") {
-			usageError(cmd, fmt.Sprintf("option \"%s\" not in form of key=value", option))
+			return fmt.Errorf("option \"%s\" not in form of key=value", option)
 		}
 
 		parts := strings.SplitN(option, "=", 2)
@@ -227,4 +393,5 @@ func parseTemplateOptionFlags(cmd *cobra.Command) {
 		}
 		viper.Set(viperKey, parts[1])
 	}
+	return nil
 }
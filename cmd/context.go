@@ -0,0 +1,313 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bdclark/nomadctl/logging"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// contextFileName is the name of the file in the home directory that
+// holds the context saved with "nomadctl context use"
+const contextFileName = ".nomadctl-context"
+
+// contextCmd represents the base "context" command
+var contextCmd = &cobra.Command{
+	Use:   "context",
+	Short: "Manage named cluster contexts",
+	Long: `Manages the named cluster contexts defined in the config file.
+
+A context is a named set of config settings, such as Nomad and Consul
+client settings, a prefix and deploy defaults, that is layered over the
+rest of the config file. For example:
+
+context: dev
+contexts:
+  dev:
+    nomad:
+      address: http://nomad.dev.example.com:4646
+    consul:
+      address: consul.dev.example.com:8500
+    prefix: dev/jobs
+  prod-east:
+    nomad:
+      address: https://nomad.east.example.com:4646
+      region: east
+      ca_cert: /etc/ssl/prod-ca.pem
+      token_file: ~/.nomad-prod-token
+    consul:
+      address: https://consul.east.example.com:8501
+      token_env: CONSUL_PROD_TOKEN
+    prefix: prod/jobs
+    deploy:
+      auto_revert: true
+      timeout: 15m
+
+The context used is given by the global "context" flag, the
+NOMADCTL_CONTEXT environment variable, the context saved with
+"nomadctl context use", or the "context" setting in the config file, in
+that order. Settings given by command-line flag, Consul key or environment
+variable take precedence over those of the context, which in turn take
+precedence over the rest of the config file.`,
+}
+
+var contextListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the contexts defined in the config file",
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := loadConfig(cmd); err != nil {
+			bail(err, 1)
+		}
+
+		current, err := currentContext(cmd)
+		if err != nil {
+			bail(err, 1)
+		}
+
+		names := contextNames()
+		if len(names) == 0 {
+			bail(fmt.Errorf("no contexts defined in config file"), 1)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "Current\tName\tNomad Address\tConsul Address\tPrefix")
+		for _, name := range names {
+			settings, _ := contextSettings(name)
+
+			marker := ""
+			if strings.EqualFold(name, current) {
+				marker = "*"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", marker, name,
+				contextString(settings, "nomad", "address"),
+				contextString(settings, "consul", "address"),
+				contextString(settings, "prefix"))
+		}
+		w.Flush()
+	},
+}
+
+var contextUseCmd = &cobra.Command{
+	Use:   "use NAME",
+	Short: "Set the current context",
+	Long: `Sets the context used by later commands, unless overridden by the
+"context" flag or the NOMADCTL_CONTEXT environment variable. The context
+is saved in "$HOME/.nomadctl-context".`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := loadConfig(cmd); err != nil {
+			bail(err, 1)
+		}
+
+		if _, err := contextSettings(args[0]); err != nil {
+			bail(err, 1)
+		}
+
+		path, err := contextFile()
+		if err != nil {
+			bail(err, 1)
+		}
+		if err := ioutil.WriteFile(path, []byte(args[0]+"\n"), 0644); err != nil {
+			bail(errors.Wrap(err, "failed to save context"), 1)
+		}
+
+		fmt.Fprintf(os.Stderr, "Using context \"%s\"\n", args[0])
+	},
+}
+
+var contextShowCmd = &cobra.Command{
+	Use:   "show [NAME]",
+	Short: "Show the settings of a context",
+	Long: `Shows the settings of the named context, or of the current context
+if NAME is not given. Tokens are redacted.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := loadConfig(cmd); err != nil {
+			bail(err, 1)
+		}
+
+		name, err := currentContext(cmd)
+		if err != nil {
+			bail(err, 1)
+		}
+		if len(args) == 1 {
+			name = args[0]
+		}
+		if name == "" {
+			bail(fmt.Errorf("no current context"), 1)
+		}
+
+		settings, err := contextSettings(name)
+		if err != nil {
+			bail(err, 1)
+		}
+		for _, client := range []string{"nomad", "consul"} {
+			if m, ok := settings[client].(map[interface{}]interface{}); ok && m["token"] != nil {
+				m["token"] = "<redacted>"
+			}
+		}
+
+		out, err := yaml.Marshal(map[string]interface{}{name: settings})
+		if err != nil {
+			bail(err, 1)
+		}
+		fmt.Print(string(out))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(contextCmd)
+	contextCmd.AddCommand(contextListCmd)
+	contextCmd.AddCommand(contextUseCmd)
+	contextCmd.AddCommand(contextShowCmd)
+
+	rootCmd.PersistentFlags().String("context", "", "config context to use")
+
+	for _, c := range contextCmd.Commands() {
+		addConfigFlags(c)
+	}
+}
+
+// useContext layers the settings of the current context, if any, over
+// those of the config file
+func useContext(cmd *cobra.Command) error {
+	name, err := currentContext(cmd)
+	if err != nil || name == "" {
+		return err
+	}
+	return applyContext(name)
+}
+
+// applyContext layers the settings of the named context over those of
+// the config file
+func applyContext(name string) error {
+	settings, err := contextSettings(name)
+	if err != nil {
+		return err
+	}
+	delete(settings, "context")
+	delete(settings, "contexts")
+
+	doc, err := yaml.Marshal(settings)
+	if err != nil {
+		return errors.Wrapf(err, "failed to use context \"%s\"", name)
+	}
+
+	// context settings are merged as yaml, whatever the config file format,
+	// then the config file's own type is restored for later reads of it
+	// (viper ignores an empty type, so it cannot simply be unset)
+	viper.SetConfigType("yaml")
+	defer viper.SetConfigType(configFileType())
+	if err := viper.MergeConfig(bytes.NewReader(doc)); err != nil {
+		return errors.Wrapf(err, "failed to use context \"%s\"", name)
+	}
+
+	logging.Debug("using context \"%s\"", name)
+	return nil
+}
+
+// configFileType returns the type of the config file in use, given by its
+// extension, or "yaml" if no config file is in use
+func configFileType() string {
+	if ext := filepath.Ext(viper.ConfigFileUsed()); ext != "" {
+		return strings.TrimPrefix(ext, ".")
+	}
+	return "yaml"
+}
+
+// currentContext returns the name of the context to use, given by the
+// "context" flag or environment variable, the context saved with
+// "nomadctl context use", or the config file, in that order
+func currentContext(cmd *cobra.Command) (string, error) {
+	if f := cmd.Flags().Lookup("context"); (f != nil && f.Changed) || os.Getenv("NOMADCTL_CONTEXT") != "" {
+		return viper.GetString("context"), nil
+	}
+
+	path, err := contextFile()
+	if err != nil {
+		return "", err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", errors.Wrap(err, "failed to read saved context")
+	}
+	if name := strings.TrimSpace(string(b)); name != "" {
+		return name, nil
+	}
+
+	return viper.GetString("context"), nil
+}
+
+// contextFile returns the path of the file holding the saved context
+func contextFile() (string, error) {
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, contextFileName), nil
+}
+
+// contextNames returns the sorted names of the contexts in the config file
+func contextNames() []string {
+	var names []string
+	for name := range viper.GetStringMap("contexts") {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// contextSettings returns the settings of the named context
+func contextSettings(name string) (map[string]interface{}, error) {
+	var value interface{}
+	for k, v := range viper.GetStringMap("contexts") {
+		if strings.EqualFold(k, name) {
+			value = v
+		}
+	}
+	if value == nil {
+		return nil, fmt.Errorf("context \"%s\" not found in config file", name)
+	}
+
+	// normalize the settings, which may have been read from any format
+	doc, err := yaml.Marshal(value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read context \"%s\"", name)
+	}
+	settings := make(map[string]interface{})
+	if err := yaml.Unmarshal(doc, &settings); err != nil {
+		return nil, errors.Wrapf(err, "failed to read context \"%s\"", name)
+	}
+	return settings, nil
+}
+
+// contextString returns the string at the given path of context settings
+func contextString(settings map[string]interface{}, path ...string) string {
+	var value interface{} = settings
+	for _, key := range path {
+		switch m := value.(type) {
+		case map[string]interface{}:
+			value = m[key]
+		case map[interface{}]interface{}:
+			value = m[key]
+		default:
+			return ""
+		}
+	}
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
@@ -1,10 +1,16 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/bdclark/nomadctl/deploy"
+	"github.com/bdclark/nomadctl/logging"
+	"github.com/bdclark/nomadctl/ops"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -18,7 +24,33 @@ the resulting job to Nomad.
 To deploy a job with the template source and deployment options specified
 on the command-line, use the "deploy template" sub-command. To deploy a job
 with the template source and deployment options specified in Consul, use
-use the "deploy kv" sub-command.`,
+use the "deploy kv" sub-command.
+
+To monitor a deployment that is already in progress, such as one started
+with the "--detach" flag, use the "deploy watch" sub-command.
+
+To deploy a plan saved with "nomadctl plan --out", use the "plan-file"
+flag. The saved job is registered exactly as it was planned, without
+rendering its template again or updating its counts from the remote job,
+and only if the remote job has not changed since it was planned. The
+deploy settings saved in the plan, such as "auto-promote", "timeout" and
+the "deploy.analysis_max_*" thresholds, are used unless overridden with
+command-line flags.
+If the saved settings require approval, the deployment waits for
+"nomadctl approve" of the JOBKEY it was planned from, and a plan made
+without a JOBKEY is refused.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		planFile, _ := cmd.Flags().GetString("plan-file")
+		if planFile == "" {
+			cmd.Help()
+			return
+		}
+		if err := initConfig(cmd); err != nil {
+			bail(err, 1)
+		}
+		doDeployPlanFile(cmd, planFile)
+	},
 }
 
 var deployTemplateCmd = &cobra.Command{
@@ -32,21 +64,74 @@ source and template rendering options.
 
 Once rendered, the job is registered with Nomad and monitored until
 the deployment is complete. If the deployment fails, details of
-the failed allocation(s) are logged.
+the failed allocation(s) are logged. Use the "detach" flag to instead
+print the evaluation and deployment IDs as JSON once the job's
+evaluation is complete, then exit without monitoring the deployment.
+Use the "json" flag to print the result of the monitored deployment as
+JSON: the job, evaluation and deployment IDs, whether it succeeded, any
+error, and the canary analysis report, if any, as "canary_analysis".
+
+If the "plan" flag is set, the job is planned before it is deployed, and
+the job is only registered if the remote job has not changed since it was
+planned. If it has changed, the deployment is abandoned and the new plan
+is displayed. If the plan has changes, confirmation is asked for before
+deploying, unless the "yes" flag or "deploy.skip_confirmation" setting is
+set.
 
 If the job is configured with canary(s), the deployment can be
 automatically promoted once the canary(s) are healthy using the
 "auto-promote" command-line flag or related config file or environment
 variable setting.
 
+Use the "canary-soak" flag or "deploy.canary_soak" setting to keep
+watching healthy canaries for a duration before they are auto-promoted.
+If a canary restarts, becomes unhealthy, or any Consul check of its
+services stops passing during the soak, even briefly, the Nomad
+deployment is failed instead of promoted (and the "on-failure" action
+taken). The soak also fails if the checks cannot be read from Consul.
+Once the soak completes a summary is logged and the canaries are promoted.
+
+Use the "canary-analysis" flag or "deploy.canary_analysis" setting to
+compare healthy canaries against the stable allocations of the same task
+group before they are auto-promoted. Restarts, failure events (such as
+"Driver Failure") and CPU and memory usage are compared, and if the
+canaries are worse by more than the "deploy.analysis_max_*" thresholds,
+the Nomad deployment is failed instead of promoted. The analysis report
+is logged, and included in the result printed with the "json" flag.
+
+By default every task group is promoted at once, when all of their
+canaries are healthy. Use the "promote-order" flag or
+"deploy.promote_order" setting (e.g. "api,worker") to instead promote
+each group as soon as its own canaries are healthy, in the given order.
+Groups joined with "+" (e.g. "api+web,worker") are promoted in the same
+wave, and groups not listed are promoted in a final wave. Each wave waits
+for the previous one to be promoted, plus "promote-pause" if set. With a
+"canary-soak", each group's canaries soak before the group is promoted.
+
 By default, if a remote job is running with the same name, nomadctl
 will update the count within each task group to match that of the
 remote job. Use the "force-count" command-line flag or related config
 file or environment variable setting to force the deployment to use
-the count(s) defined in the job template.`,
+the count(s) defined in the job template.
+
+If a "timeout" is set and the deployment is not complete within that
+duration, the Nomad deployment is failed and the groups that are still
+unhealthy are logged. If "auto-revert" is also set, the job is then
+reverted to its last stable version.
+
+Use the "on-failure" flag or "deploy.on_failure" setting to choose what
+happens when the job fails to deploy, such as a failed Nomad deployment,
+evaluation or timeout. With "none" (the default) the failed job is left in
+place. With "revert" the job is reverted to its last stable version, even
+if its update stanza does not set "auto_revert", or to the version it
+replaced if no version is stable (such as for a system job).
+With "revert-and-wait" the revert is then monitored too, and both outcomes
+are reported.`,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		initConfig(cmd)
+		if err := initConfig(cmd); err != nil {
+			bail(err, 1)
+		}
 		viper.Set("template.source", args[0])
 		doDeploy(cmd, "")
 	},
@@ -69,17 +154,76 @@ related Consul keys, the following deployment-related Consul keys are
 supported:
 
 "${JOBKEY}/deploy/auto_promote" same as "--auto-promote" flag
+"${JOBKEY}/deploy/auto_revert" same as "--auto-revert" flag
+"${JOBKEY}/deploy/canary_analysis" same as "--canary-analysis" flag
+"${JOBKEY}/deploy/canary_soak" same as "--canary-soak" flag
 "${JOBKEY}/deploy/force_count" same as "--force-count" flag
+"${JOBKEY}/deploy/on_failure" same as "--on-failure" flag
+"${JOBKEY}/deploy/promote_order" same as "--promote-order" flag
+"${JOBKEY}/deploy/promote_pause" same as "--promote-pause" flag
+"${JOBKEY}/deploy/require_approval" same as "--require-approval" flag
+"${JOBKEY}/deploy/timeout" same as "--timeout" flag
+
+Once registered, the job's ID is recorded in Consul under
+"${PREFIX}/.nomadctl/jobs", along with the full JOBKEY it was deployed
+from, which "nomadctl reconcile" uses to find the jobs deployed from a
+prefix. The job itself is not changed.
 
 Once rendered, the job is registered with Nomad and monitored until
 the deployment is complete. If the deployment fails, details of
-the failed allocation(s) are logged.
+the failed allocation(s) are logged. Use the "detach" flag to instead
+print the evaluation and deployment IDs as JSON once the job's
+evaluation is complete, then exit without monitoring the deployment.
+Use the "json" flag to print the result of the monitored deployment as
+JSON: the job, evaluation and deployment IDs, whether it succeeded, any
+error, and the canary analysis report, if any, as "canary_analysis".
+
+If the "plan" flag is set, the job is planned before it is deployed, and
+the job is only registered if the remote job has not changed since it was
+planned. If it has changed, the deployment is abandoned and the new plan
+is displayed. If the plan has changes, confirmation is asked for before
+deploying, unless the "yes" flag or "deploy.skip_confirmation" setting is
+set.
 
 If the job is configured with canary(s), the deployment can be
 automatically promoted once the canary(s) are healthy using the
 "auto-promote" command-line flag, config file setting, environment
 variable, or Consul key.
 
+Use the "canary-soak" flag or "deploy.canary_soak" setting to keep
+watching healthy canaries for a duration before they are auto-promoted.
+If a canary restarts, becomes unhealthy, or any Consul check of its
+services stops passing during the soak, even briefly, the Nomad
+deployment is failed instead of promoted (and the "on-failure" action
+taken). The soak also fails if the checks cannot be read from Consul.
+Once the soak completes a summary is logged and the canaries are promoted.
+
+Use the "canary-analysis" flag or "deploy.canary_analysis" setting to
+compare healthy canaries against the stable allocations of the same task
+group before they are auto-promoted. Restarts, failure events (such as
+"Driver Failure") and CPU and memory usage are compared, and if the
+canaries are worse by more than the "deploy.analysis_max_*" thresholds,
+the Nomad deployment is failed instead of promoted. The analysis report
+is logged, and included in the result printed with the "json" flag.
+
+By default every task group is promoted at once, when all of their
+canaries are healthy. Use the "promote-order" flag or
+"deploy.promote_order" setting (e.g. "api,worker") to instead promote
+each group as soon as its own canaries are healthy, in the given order.
+Groups joined with "+" (e.g. "api+web,worker") are promoted in the same
+wave, and groups not listed are promoted in a final wave. Each wave waits
+for the previous one to be promoted, plus "promote-pause" if set. With a
+"canary-soak", each group's canaries soak before the group is promoted.
+
+Use the "require-approval" flag or "deploy.require_approval" setting to
+wait for a person to approve healthy canaries before they are promoted,
+even without "auto-promote". A pending approval is written to
+"${JOBKEY}/deploy/approvals/${DEPLOYMENT_ID}", and the deployment waits
+until it is approved with "nomadctl approve JOBKEY", which promotes the
+canaries, or rejected with "nomadctl reject JOBKEY", which fails the Nomad
+deployment (and takes the "on-failure" action). With "promote-order",
+only the first wave waits for approval.
+
 By default, if a remote job is running with the same name, nomadctl
 will update the count within each task group to match that of the
 remote job so the number of resulting allocations will not change.
@@ -87,20 +231,177 @@ Use the "force-count" command-line flag or related config file,
 environment variable, or Consul KV setting to force the deployment
 to use the count(s) defined in the job template.
 
+If a "timeout" is set and the deployment is not complete within that
+duration, the Nomad deployment is failed and the groups that are still
+unhealthy are logged. If "auto-revert" is also set, the job is then
+reverted to its last stable version.
+
+Use the "on-failure" flag or "deploy.on_failure" setting to choose what
+happens when the job fails to deploy, such as a failed Nomad deployment,
+evaluation or timeout. With "none" (the default) the failed job is left in
+place. With "revert" the job is reverted to its last stable version, even
+if its update stanza does not set "auto_revert", or to the version it
+replaced if no version is stable (such as for a system job).
+With "revert-and-wait" the revert is then monitored too, and both outcomes
+are reported.
+
+Use the "all" flag to deploy every job under a PREFIX instead of a single
+JOBKEY. If PREFIX is not specified, the configured prefix is used. Each job
+is rendered with its own Consul settings, then up to "parallel" jobs are
+deployed at once, optionally only those whose key matches the "match" glob.
+Log messages are prefixed with each job's key. Unless "continue-on-error"
+is set, no more jobs are started once a job fails. A summary of the
+results is displayed once all jobs are complete, and a non-zero exit code
+is returned if any job failed.
+
+Use the "clusters" flag or "deploy.clusters" config setting to deploy the
+job to several clusters, each given by a config context (see "nomadctl
+help context"). The job is rendered with the settings of each cluster,
+including its Consul and prefix, then deployed to one cluster after the
+other, or to several at once by joining their names with "+". For example,
+"--clusters staging,prod-east+prod-west" deploys to staging, then to both
+prod-east and prod-west at once. Each such group of clusters is a wave.
+
+If a cluster's deployment fails, later waves are not deployed, unless the
+"continue-on-error" flag is set, or the deployment is run from a terminal
+and continuing is confirmed. Use the "wave-approval" flag or
+"deploy.wave_approval" config setting to also ask for approval before each
+wave after the first. Once complete, the result of each cluster's
+deployment is displayed.
+
 Settings in Consul override config file and environment variable settings,
 However, if a command-line flag is specified, it overrides the related
 setting found in Consul.`,
-	Args: cobra.ExactArgs(1),
+	Args: batchArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		initConfig(cmd)
+		if err := initConfig(cmd); err != nil {
+			bail(err, 1)
+		}
+		all, _ := cmd.Flags().GetBool("all")
+
+		waves, err := clusterWaves(cmd)
+		if err != nil {
+			usageError(cmd, err.Error())
+		}
+		if jsonResult, _ := cmd.Flags().GetBool("json"); jsonResult && (all || len(waves) > 0) {
+			usageError(cmd, "cannot print a JSON result when deploying every job or to multiple clusters")
+		}
+		if len(waves) > 0 {
+			detach, _ := cmd.Flags().GetBool("detach")
+			switch {
+			case all:
+				usageError(cmd, "cannot deploy every job to multiple clusters")
+			case detach:
+				usageError(cmd, "cannot detach when deploying to multiple clusters")
+			case cmd.Flags().Changed("context"):
+				usageError(cmd, "cannot use context when deploying to multiple clusters")
+			}
+			doDeployClusters(cmd, args[0], waves)
+			return
+		}
+
+		if all {
+			doDeployAll(cmd, args)
+			return
+		}
 		doDeploy(cmd, args[0])
 	},
 }
 
+var deployWatchCmd = &cobra.Command{
+	Use:   "watch DEPLOYMENT_ID|JOB",
+	Short: "Monitor an in-flight deployment",
+	Long: `Monitors an existing Nomad deployment until it is complete, the same
+way the deployment would have been monitored had it not been detached.
+
+The required argument is either a deployment ID (or unique ID prefix),
+or the name of a job, in which case the job's latest deployment is
+monitored. If the deployment fails, details of the failed allocation(s)
+are logged and a non-zero exit code is returned.
+
+If the deployment has canaries, they can be automatically promoted once
+healthy using the "auto-promote" command-line flag or related config
+file or environment variable setting. Similarly, the "canary-soak",
+"canary-analysis", "promote-order", "timeout" and "auto-revert" settings
+behave the same as for the other "deploy"
+sub-commands, as does the "json" flag.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := initConfig(cmd); err != nil {
+			bail(err, 1)
+		}
+
+		if _, err := approvalKey(""); err != nil {
+			usageError(cmd, err.Error())
+		}
+
+		client, err := nomadClient()
+		if err != nil {
+			bail(err, 1)
+		}
+		monitorClient, err := monitorConsulClient("")
+		if err != nil {
+			bail(err, 1)
+		}
+
+		onFailure, err := failureAction()
+		if err != nil {
+			usageError(cmd, err.Error())
+		}
+		order, err := promoteOrder()
+		if err != nil {
+			usageError(cmd, err.Error())
+		}
+
+		input := &deploy.ExistingDeploymentInput{
+			Client:         client,
+			AutoPromote:    viper.GetBool("deploy.auto_promote"),
+			CanarySoak:     viper.GetDuration("deploy.canary_soak"),
+			ConsulClient:   monitorClient,
+			PromoteOrder:   order,
+			PromotePause:   viper.GetDuration("deploy.promote_pause"),
+			CanaryAnalysis: canaryAnalysis(),
+			Timeout:        viper.GetDuration("deploy.timeout"),
+			AutoRevert:     viper.GetBool("deploy.auto_revert"),
+			OnFailure:      onFailure,
+			Verbose:        false,
+		}
+
+		// try the argument as a deployment ID first, falling back to a job name
+		var deployment *deploy.Deployment
+		if deploy.IsDeploymentID(args[0]) {
+			input.DeploymentID = args[0]
+			deployment, err = deploy.NewExistingDeployment(input)
+		}
+		if deployment == nil {
+			input.DeploymentID = ""
+			input.JobName = args[0]
+			deployment, err = deploy.NewExistingDeployment(input)
+		}
+		if err != nil {
+			bail(err, 1)
+		}
+
+		success, err := deployment.Watch(interruptContext(cmd))
+		printDeployResult(cmd, deployment, success, err)
+		if err != nil {
+			bail(err, 1)
+		}
+	},
+}
+
 func init() {
 	rootCmd.AddCommand(deployCmd)
 	deployCmd.AddCommand(deployTemplateCmd)
 	deployCmd.AddCommand(deployKVCmd)
+	deployCmd.AddCommand(deployWatchCmd)
+
+	addConfigFlags(deployCmd)
+	addMonitorFlags(deployCmd)
+	deployCmd.Flags().String("plan-file", "", "deploy a plan saved with \"plan --out\"")
+	deployCmd.Flags().Bool("auto-promote", false, "automatically promote canary deployment")
+	deployCmd.Flags().Bool("detach", false, "print evaluation and deployment IDs as JSON and exit without monitoring")
+	deployCmd.Flags().Bool("json", false, "print the result of the monitored deployment as JSON, including any canary analysis")
 
 	addConfigFlags(deployTemplateCmd)
 	addDeployFlags(deployTemplateCmd)
@@ -108,40 +409,344 @@ func init() {
 	addConfigFlags(deployKVCmd)
 	addConsulFlags(deployKVCmd)
 	addDeployFlags(deployKVCmd)
+	addBatchFlags(deployKVCmd)
+	deployKVCmd.Flags().StringSlice("clusters", []string{}, "config contexts to deploy to in turn, join contexts with \"+\" to deploy them at once")
+	deployKVCmd.Flags().Bool("wave-approval", false, "ask for approval before deploying each wave of clusters after the first")
+	deployKVCmd.Flags().Bool("require-approval", false, "wait for \"nomadctl approve\" before promoting healthy canaries")
+
+	addConfigFlags(deployWatchCmd)
+	addMonitorFlags(deployWatchCmd)
+	deployWatchCmd.Flags().Bool("auto-promote", false, "automatically promote canary deployment")
+	deployWatchCmd.Flags().Bool("json", false, "print the result of the monitored deployment as JSON, including any canary analysis")
 }
 
 func doDeploy(cmd *cobra.Command, consulJobKey string) {
 	// render template (and set related consul config if applicable)
 	jobspec := doRender(cmd, consulJobKey, 1)
 
-	// create deployment
-	deployment, err := deploy.NewDeployment(&deploy.NewDeploymentInput{
-		AutoPromote:      viper.GetBool("deploy.auto_promote"),
-		UseTemplateCount: viper.GetBool("deploy.force_count"),
-		Verbose:          false,
-		Jobspec:          &jobspec,
-	})
+	detach, _ := cmd.Flags().GetBool("detach")
+
+	onFailure, err := failureAction()
+	if err != nil {
+		usageError(cmd, err.Error())
+	}
+	order, err := promoteOrder()
+	if err != nil {
+		usageError(cmd, err.Error())
+	}
+	approvals, err := approvalKey(consulJobKey)
+	if err != nil {
+		usageError(cmd, err.Error())
+	}
+
+	client, err := nomadClient()
+	if err != nil {
+		bail(err, 1)
+	}
+	monitorClient, err := monitorConsulClient(consulJobKey)
 	if err != nil {
 		bail(err, 1)
 	}
 
+	o := &ops.DeployOptions{
+		Jobspec:          jobspec,
+		JobKey:           deployedJobKey(consulJobKey),
+		AutoPromote:      viper.GetBool("deploy.auto_promote"),
+		CanarySoak:       viper.GetDuration("deploy.canary_soak"),
+		ConsulClient:     monitorClient,
+		PromoteOrder:     order,
+		PromotePause:     viper.GetDuration("deploy.promote_pause"),
+		CanaryAnalysis:   canaryAnalysis(),
+		ApprovalKey:      approvals,
+		UseTemplateCount: viper.GetBool("deploy.force_count"),
+		Timeout:          viper.GetDuration("deploy.timeout"),
+		AutoRevert:       viper.GetBool("deploy.auto_revert"),
+		OnFailure:        onFailure,
+		Detach:           detach,
+	}
+
 	// run a job plan if specified
 	if viper.GetBool("deploy.plan") {
-		changes, err := deployment.Plan(false, false, true, false)
+		plan, err := ops.Plan(context.Background(), client, &ops.PlanOptions{
+			Jobspec:          jobspec,
+			UseTemplateCount: o.UseTemplateCount,
+			Output:           &deploy.PlanInput{Diff: true},
+		})
 		if err != nil {
 			bail(err, 1)
 		}
 
-		if changes && !viper.GetBool("deploy.force") {
+		if plan.Changes && !viper.GetBool("deploy.skip_confirmation") {
 			if confirm := askForConfirmation("Changes found, continue deployment?"); !confirm {
 				fmt.Fprintln(os.Stderr, "Abandoning deployment.")
 				os.Exit(0)
 			}
 		}
+
+		// only register the job if it hasn't changed since it was planned
+		o.EnforceIndex = true
+		o.JobModifyIndex = plan.Deployment.JobModifyIndex()
 	}
 
 	// deploy
-	if _, err = deployment.Deploy(); err != nil {
+	result, err := ops.Deploy(interruptContext(cmd), client, o)
+	if result != nil && !detach {
+		printDeployResult(cmd, result.Deployment, result.Success, err)
+	}
+	if err != nil {
+		if err == deploy.ErrJobModified {
+			fmt.Fprintln(os.Stderr, "The remote job changed since it was planned, the new plan is:")
+			fmt.Fprintln(os.Stderr, "")
+			if _, planErr := result.Deployment.Plan(&deploy.PlanInput{Diff: true}); planErr != nil {
+				logging.Error("%v", planErr)
+			}
+		}
+		bail(err, 1)
+	}
+
+	if detach {
+		printDeploymentIDs(result.Deployment)
+	}
+}
+
+// doDeployAll deploys every job under a prefix
+func doDeployAll(cmd *cobra.Command, args []string) {
+	specs, err := allJobSpecs(cmd, args)
+	if err != nil {
+		bail(err, 1)
+	}
+
+	continueOnError, _ := cmd.Flags().GetBool("continue-on-error")
+	parallel, _ := cmd.Flags().GetInt("parallel")
+
+	jobs, failed, err := renderBatchJobs(cmd, specs, continueOnError)
+	if err != nil {
+		bail(err, 1)
+	}
+	batch, err := deploy.NewBatch(jobs)
+	if err != nil {
+		bail(err, 1)
+	}
+
+	// run a plan of every job first if specified
+	if viper.GetBool("deploy.plan") {
+		changes, err := planBatchJobs(batch, jobs)
+		if err != nil {
+			bail(err, 1)
+		}
+
+		if changes && !viper.GetBool("deploy.skip_confirmation") {
+			if confirm := askForConfirmation("Changes found, continue deployment?"); !confirm {
+				fmt.Fprintln(os.Stderr, "Abandoning deployment.")
+				os.Exit(0)
+			}
+		}
+	}
+
+	results := batch.Run(interruptContext(cmd), &deploy.BatchRunInput{
+		Parallel:    parallel,
+		StopOnError: !continueOnError,
+	})
+	results = append(failed, results...)
+	deploy.PrintBatchResults(results)
+
+	for _, r := range results {
+		if !r.Success {
+			os.Exit(1)
+		}
+	}
+}
+
+// deploySettingFlags are the deploy settings whose command-line flag is
+// not named after the setting
+var deploySettingFlags = map[string]string{
+	"skip_confirmation": "yes",
+}
+
+func doDeployPlanFile(cmd *cobra.Command, path string) {
+	plan, err := deploy.ReadPlanFile(path)
+	if err != nil {
+		bail(err, 1)
+	}
+
+	// use the deploy settings saved with the plan unless flags are set
+	if settings, ok := plan.Config["deploy"].(map[string]interface{}); ok {
+		for key, value := range settings {
+			flag, ok := deploySettingFlags[key]
+			if !ok {
+				flag = strings.Replace(key, "_", "-", -1)
+			}
+			if f := cmd.Flags().Lookup(flag); f != nil && f.Changed {
+				logging.Debug("ignoring planned deploy setting %s because %s flag set", key, flag)
+				continue
+			}
+			viper.Set("deploy."+key, value)
+		}
+	}
+
+	// approvals are kept under the job key the plan was made from
+	var approvals string
+	if viper.GetBool("deploy.require_approval") {
+		if plan.JobKey == "" {
+			usageError(cmd, "deploy.require_approval is only supported for plans of a JOBKEY")
+		}
+		approvals = plan.JobKey + "/" + deploy.ApprovalsKey
+	}
+
+	detach, _ := cmd.Flags().GetBool("detach")
+
+	onFailure, err := failureAction()
+	if err != nil {
+		usageError(cmd, err.Error())
+	}
+	order, err := promoteOrder()
+	if err != nil {
+		usageError(cmd, err.Error())
+	}
+
+	client, err := nomadClient()
+	if err != nil {
+		bail(err, 1)
+	}
+	monitorClient, err := monitorConsulClient(plan.JobKey)
+	if err != nil {
+		bail(err, 1)
+	}
+
+	deployment, err := deploy.NewDeployment(&deploy.NewDeploymentInput{
+		Client:         client,
+		JobKey:         plan.JobKey,
+		OnFailure:      onFailure,
+		Job:            plan.Job,
+		EnforceIndex:   true,
+		JobModifyIndex: plan.JobModifyIndex,
+		Prepared:       true,
+		AutoPromote:    viper.GetBool("deploy.auto_promote"),
+		CanarySoak:     viper.GetDuration("deploy.canary_soak"),
+		ConsulClient:   monitorClient,
+		PromoteOrder:   order,
+		PromotePause:   viper.GetDuration("deploy.promote_pause"),
+		CanaryAnalysis: canaryAnalysis(),
+		ApprovalKey:    approvals,
+		Timeout:        viper.GetDuration("deploy.timeout"),
+		AutoRevert:     viper.GetBool("deploy.auto_revert"),
+		Detach:         detach,
+		Verbose:        false,
+	})
+	if err != nil {
+		bail(err, 1)
+	}
+
+	logging.Info("deploying job \"%s\" as planned at %s", *plan.Job.Name, plan.Created.Format(time.RFC3339))
+
+	// refuse to deploy if the remote job has moved since it was planned
+	if err := deployment.VerifyPlan(plan.DiffHash); err != nil {
+		if err == deploy.ErrJobModified {
+			bail(fmt.Errorf("remote job \"%s\" changed since it was planned, plan it again", *plan.Job.Name), 1)
+		}
+		bail(err, 1)
+	}
+
+	success, err := deployment.Deploy(interruptContext(cmd))
+	if !detach {
+		printDeployResult(cmd, deployment, success, err)
+	}
+	if err != nil {
+		if err == deploy.ErrJobModified {
+			err = fmt.Errorf("remote job \"%s\" changed since it was planned, plan it again", *plan.Job.Name)
+		}
+		bail(err, 1)
+	}
+
+	if detach {
+		printDeploymentIDs(deployment)
+	}
+}
+
+// deployResult is the result of a monitored deployment, printed as JSON
+// with the "json" flag
+type deployResult struct {
+	JobID          string                 `json:"job_id"`
+	EvalID         string                 `json:"eval_id,omitempty"`
+	DeploymentID   string                 `json:"deployment_id,omitempty"`
+	Success        bool                   `json:"success"`
+	Error          string                 `json:"error,omitempty"`
+	CanaryAnalysis *deploy.CanaryAnalysis `json:"canary_analysis,omitempty"`
+}
+
+// printDeployResult prints the result of a monitored deployment as JSON,
+// if the "json" flag is set
+func printDeployResult(cmd *cobra.Command, deployment *deploy.Deployment, success bool, err error) {
+	if jsonResult, _ := cmd.Flags().GetBool("json"); !jsonResult {
+		return
+	}
+
+	out := &deployResult{
+		JobID:          deployment.JobID(),
+		EvalID:         deployment.EvalID(),
+		DeploymentID:   deployment.DeploymentID(),
+		Success:        success && err == nil,
+		CanaryAnalysis: deployment.CanaryAnalysis(),
+	}
+	if err != nil {
+		out.Error = err.Error()
+	}
+
+	if err := json.NewEncoder(os.Stdout).Encode(out); err != nil {
 		bail(err, 1)
 	}
 }
+
+// printDeploymentIDs prints the IDs needed to re-attach to a detached deployment
+func printDeploymentIDs(deployment *deploy.Deployment) {
+	out := struct {
+		EvalID       string `json:"eval_id"`
+		DeploymentID string `json:"deployment_id"`
+	}{deployment.EvalID(), deployment.DeploymentID()}
+
+	if err := json.NewEncoder(os.Stdout).Encode(out); err != nil {
+		bail(err, 1)
+	}
+}
+
+// failureAction returns the configured action to take when a job fails to deploy
+func failureAction() (deploy.FailureAction, error) {
+	return deploy.ParseFailureAction(viper.GetString("deploy.on_failure"))
+}
+
+// promoteOrder returns the configured waves of task groups to promote in turn
+func promoteOrder() ([][]string, error) {
+	return deploy.ParsePromoteOrder(viper.GetStringSlice("deploy.promote_order"))
+}
+
+// approvalKey returns the Consul key under which deployments of the given
+// job key await approval before their canaries are promoted, or an empty
+// string if approval is not required. Approval requires a job key.
+func approvalKey(jobKey string) (string, error) {
+	if !viper.GetBool("deploy.require_approval") {
+		return "", nil
+	}
+	if jobKey == "" {
+		return "", fmt.Errorf("deploy.require_approval is only supported when deploying a JOBKEY")
+	}
+	return canonicalizeJobKey(jobKey) + "/" + deploy.ApprovalsKey, nil
+}
+
+// canaryAnalysis returns the thresholds canaries are analyzed with before
+// they are auto-promoted, or nil if canaries are not analyzed
+func canaryAnalysis() *deploy.AnalysisThresholds {
+	if !viper.GetBool("deploy.canary_analysis") {
+		return nil
+	}
+	return analysisThresholds()
+}
+
+// analysisThresholds returns the configured canary analysis thresholds
+func analysisThresholds() *deploy.AnalysisThresholds {
+	return &deploy.AnalysisThresholds{
+		MaxRestarts:       viper.GetFloat64("deploy.analysis_max_restarts"),
+		MaxFailureEvents:  viper.GetFloat64("deploy.analysis_max_failure_events"),
+		MaxCPUIncrease:    viper.GetFloat64("deploy.analysis_max_cpu_increase"),
+		MaxMemoryIncrease: viper.GetFloat64("deploy.analysis_max_memory_increase"),
+	}
+}
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"github.com/bdclark/nomadctl/deploy"
+	"github.com/spf13/cobra"
+)
+
+// deploymentCmd represents the base "deployment" command
+var deploymentCmd = &cobra.Command{
+	Use:   "deployment",
+	Short: "Interact with a job's latest deployment",
+	Long: `Controls the latest Nomad deployment of a job, such as promoting
+canaries that were not automatically promoted, failing, pausing or
+resuming the deployment, or displaying its status.`,
+}
+
+var deploymentPromoteCmd = &cobra.Command{
+	Use:   "promote JOB",
+	Short: "Promote the canaries of a job's latest deployment",
+	Long: `Promotes the canaries of a job's latest deployment.
+
+By default, the canaries of every task group are promoted. Use the
+"--group" flag to promote only specific task groups.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := initConfig(cmd); err != nil {
+			bail(err, 1)
+		}
+		groups, _ := cmd.Flags().GetStringSlice("group")
+
+		if err := latestDeployment(args[0]).Promote(groups); err != nil {
+			bail(err, 1)
+		}
+	},
+}
+
+var deploymentFailCmd = &cobra.Command{
+	Use:   "fail JOB",
+	Short: "Fail a job's latest deployment",
+	Long: `Marks a job's latest deployment as failed. If the job's update stanza
+has auto_revert set, Nomad reverts the job to its last stable version.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := initConfig(cmd); err != nil {
+			bail(err, 1)
+		}
+
+		if err := latestDeployment(args[0]).Fail(); err != nil {
+			bail(err, 1)
+		}
+	},
+}
+
+var deploymentPauseCmd = &cobra.Command{
+	Use:   "pause JOB",
+	Short: "Pause a job's latest deployment",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := initConfig(cmd); err != nil {
+			bail(err, 1)
+		}
+
+		if err := latestDeployment(args[0]).Pause(true); err != nil {
+			bail(err, 1)
+		}
+	},
+}
+
+var deploymentResumeCmd = &cobra.Command{
+	Use:   "resume JOB",
+	Short: "Resume a job's paused deployment",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := initConfig(cmd); err != nil {
+			bail(err, 1)
+		}
+
+		if err := latestDeployment(args[0]).Pause(false); err != nil {
+			bail(err, 1)
+		}
+	},
+}
+
+var deploymentStatusCmd = &cobra.Command{
+	Use:   "status JOB",
+	Short: "Display the status of a job's latest deployment",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := initConfig(cmd); err != nil {
+			bail(err, 1)
+		}
+
+		if err := latestDeployment(args[0]).PrintStatus(); err != nil {
+			bail(err, 1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(deploymentCmd)
+	deploymentCmd.AddCommand(deploymentPromoteCmd)
+	deploymentCmd.AddCommand(deploymentFailCmd)
+	deploymentCmd.AddCommand(deploymentPauseCmd)
+	deploymentCmd.AddCommand(deploymentResumeCmd)
+	deploymentCmd.AddCommand(deploymentStatusCmd)
+
+	for _, c := range deploymentCmd.Commands() {
+		addConfigFlags(c)
+	}
+	deploymentPromoteCmd.Flags().StringSlice("group", []string{}, "group to promote (can be supplied multiple times)")
+}
+
+// latestDeployment returns the latest deployment of the given job,
+// exiting if one cannot be found
+func latestDeployment(jobName string) *deploy.Deployment {
+	client, err := nomadClient()
+	if err != nil {
+		bail(err, 1)
+	}
+
+	d, err := deploy.NewExistingDeployment(&deploy.ExistingDeploymentInput{
+		Client:  client,
+		JobName: jobName,
+		Verbose: false,
+	})
+	if err != nil {
+		bail(err, 1)
+	}
+	return d
+}
@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/bdclark/nomadctl/deploy"
+	"github.com/spf13/cobra"
+)
+
+var driftCmd = &cobra.Command{
+	Use:   "drift [JOBKEY]",
+	Short: "Find jobs that differ from their job key",
+	Long: `Renders the job defined by JOBKEY, then plans it against the job
+registered with Nomad to report whether, and in which fields, the remote
+job differs from its source of truth, such as after a job is modified with
+the Nomad CLI.
+
+See "nomadctl help render kv" for details regarding the JOBKEY argument,
+the template source, rendering options, and supported Consul keys.
+
+The job is prepared exactly as "deploy" would register it, so the count
+within each task group is preserved from the remote job and is not
+reported as drift, unless "${JOBKEY}/deploy/force_count" is set.
+
+Use the "all" flag to check every job under a PREFIX instead of a single
+JOBKEY, optionally only those whose key matches the "match" glob. If
+PREFIX is not specified, the configured prefix is used.
+
+Use the "format" flag to output a "json" report instead of "text", with
+each job's key, job ID, whether it drifted or is missing from Nomad, and
+the path, type, old and new value of each field that differs. The old
+value is that of the remote job, and the new value that of the job key.
+
+One of the following exit codes will be returned:
+* 0: No drift found.
+* 1: One or more jobs differ from their job key, or are not registered.
+* 255: Error checking one or more jobs.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := batchArgs(cmd, args); err != nil {
+			usageError(cmd, err.Error(), 255)
+		}
+		if err := initConfig(cmd); err != nil {
+			bail(err, 255)
+		}
+
+		format, _ := cmd.Flags().GetString("format")
+		if format != "text" && format != "json" {
+			usageError(cmd, fmt.Sprintf("invalid format \"%s\", must be text or json", format), 255)
+		}
+
+		var specs []*batchJobSpec
+		if all, _ := cmd.Flags().GetBool("all"); all {
+			var err error
+			if specs, err = allJobSpecs(cmd, args); err != nil {
+				bail(err, 255)
+			}
+		} else {
+			specs = []*batchJobSpec{{name: args[0], key: args[0]}}
+		}
+
+		jobs, failed, err := renderBatchJobs(cmd, specs, true)
+		if err != nil {
+			bail(err, 255)
+		}
+
+		results := []*driftResult{}
+		for _, f := range failed {
+			results = append(results, &driftResult{Name: f.Name, Error: f.Err.Error()})
+		}
+		for _, j := range jobs {
+			drift, err := j.Deployment.Drift()
+			r := &driftResult{Name: j.Name, JobDrift: drift}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to check job \"%s\": %v\n", j.Name, err)
+				r.Error = err.Error()
+			}
+			results = append(results, r)
+		}
+
+		code := 0
+		drifted := false
+		for _, r := range results {
+			switch {
+			case r.Error != "":
+				code = 255
+			case r.Drifted:
+				drifted = true
+				if code == 0 {
+					code = 1
+				}
+			}
+		}
+
+		if format == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(&driftReport{Drifted: drifted, Jobs: results}); err != nil {
+				bail(err, 255)
+			}
+			os.Exit(code)
+		}
+
+		for _, r := range results {
+			switch {
+			case r.JobDrift == nil:
+				continue
+			case r.Missing:
+				fmt.Printf("==> Job \"%s\" (%s) is not registered with Nomad\n", r.JobID, r.Name)
+			case r.Drifted:
+				fmt.Printf("==> Job \"%s\" (%s) differs from its job key:\n", r.JobID, r.Name)
+				for _, f := range r.Fields {
+					fmt.Println(formatDriftField(f))
+				}
+			}
+		}
+		if code == 0 {
+			fmt.Fprintln(os.Stderr, "No drift found.")
+		}
+		os.Exit(code)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(driftCmd)
+
+	addConfigFlags(driftCmd)
+	addConsulFlags(driftCmd)
+	driftCmd.Flags().Bool("all", false, "every job under PREFIX (or the configured prefix)")
+	driftCmd.Flags().String("match", "", "only jobs with keys matching this glob with --all")
+	driftCmd.Flags().String("format", "text", "output format: text or json")
+}
+
+// driftReport is the JSON representation of the drift command's results
+type driftReport struct {
+	Drifted bool           `json:"drifted"`
+	Jobs    []*driftResult `json:"jobs"`
+}
+
+// driftResult is the drift of a single job key, or the error checking it
+type driftResult struct {
+	Name string `json:"name"`
+	*deploy.JobDrift
+	Error string `json:"error,omitempty"`
+}
+
+// formatDriftField formats a drifted field for text output
+func formatDriftField(f *deploy.DriftField) string {
+	switch f.Type {
+	case "Added":
+		if f.New == "" {
+			return fmt.Sprintf("  + %s", f.Path)
+		}
+		return fmt.Sprintf("  + %s: %q", f.Path, f.New)
+	case "Deleted":
+		if f.Old == "" {
+			return fmt.Sprintf("  - %s", f.Path)
+		}
+		return fmt.Sprintf("  - %s: %q", f.Path, f.Old)
+	default:
+		return fmt.Sprintf("  +/- %s: %q => %q", f.Path, f.Old, f.New)
+	}
+}
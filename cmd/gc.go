@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"os"
 
-	"github.com/bdclark/nomadctl/nomad"
 	"github.com/spf13/cobra"
 )
 
@@ -15,13 +14,16 @@ var gcCmd = &cobra.Command{
 	Long:  `The gc command will force a Nomad cluster garbage collection.`,
 	Args:  cobra.ExactArgs(0),
 	Run: func(cmd *cobra.Command, args []string) {
+		if err := initConfig(cmd); err != nil {
+			bail(err, 1)
+		}
 
-		nomad, err := nomad.NewNomadClient(nil)
+		client, err := nomadClient()
 		if err != nil {
 			bail(err, 1)
 		}
 
-		if err = nomad.System().GarbageCollect(); err != nil {
+		if err = client.System().GarbageCollect(); err != nil {
 			bail(err, 1)
 		}
 
@@ -31,4 +33,6 @@ var gcCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(gcCmd)
+
+	addConfigFlags(gcCmd)
 }
@@ -3,12 +3,16 @@ package cmd
 import (
 	"bufio"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strings"
 
 	consul "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/nomad/api"
+	homedir "github.com/mitchellh/go-homedir"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 )
 
 // askForConfirmation presents a message as a yes/no question
@@ -34,6 +38,29 @@ func askForConfirmation(s string) bool {
 	}
 }
 
+// askForChoice presents a message with a list of choices and returns
+// the choice selected, which can be abbreviated by its first letter
+func askForChoice(s string, choices []string) string {
+	reader := bufio.NewReader(os.Stdin)
+
+	for {
+		fmt.Printf("%s [%s]: ", s, strings.Join(choices, "/"))
+
+		response, err := reader.ReadString('\n')
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		response = strings.ToLower(strings.TrimSpace(response))
+
+		for _, choice := range choices {
+			if response == choice || (len(response) == 1 && strings.HasPrefix(choice, response)) {
+				return choice
+			}
+		}
+	}
+}
+
 // explode is used to expand a list of keypairs into a deeply-nested hash.
 func explode(pairs *consul.KVPairs, prefix string) (map[string]interface{}, error) {
 	m := make(map[string]interface{})
@@ -69,3 +96,128 @@ func explodeHelper(m map[string]interface{}, k, v, p string) error {
 
 	return nil
 }
+
+// nomadClient returns a Nomad API client configured from the "nomad"
+// settings, falling back to the standard Nomad environment variables
+func nomadClient() (*api.Client, error) {
+	config := api.DefaultConfig()
+	if config.TLSConfig == nil {
+		config.TLSConfig = &api.TLSConfig{}
+	}
+
+	setString(&config.Address, "nomad.address")
+	setString(&config.Region, "nomad.region")
+	setString(&config.Namespace, "nomad.namespace")
+	token, err := clientToken("nomad")
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		config.SecretID = token
+	}
+	setString(&config.TLSConfig.CACert, "nomad.ca_cert")
+	setString(&config.TLSConfig.CAPath, "nomad.ca_path")
+	setString(&config.TLSConfig.ClientCert, "nomad.client_cert")
+	setString(&config.TLSConfig.ClientKey, "nomad.client_key")
+	setString(&config.TLSConfig.TLSServerName, "nomad.tls_server_name")
+	if viper.GetBool("nomad.tls_skip_verify") {
+		config.TLSConfig.Insecure = true
+	}
+
+	client, err := api.NewClient(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create Nomad client")
+	}
+	return client, nil
+}
+
+// consulClient returns a Consul API client configured from the "consul"
+// settings, falling back to the standard Consul environment variables
+func consulClient() (*consul.Client, error) {
+	config, err := consulConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := consul.NewClient(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create Consul client")
+	}
+	return client, nil
+}
+
+// consulConfig returns the Consul client config of the "consul" settings,
+// falling back to the standard Consul environment variables
+func consulConfig() (*consul.Config, error) {
+	config := consul.DefaultConfig()
+
+	if address := viper.GetString("consul.address"); address != "" {
+		// the scheme is configured separately from the address
+		parts := strings.SplitN(address, "://", 2)
+		if len(parts) == 2 {
+			config.Scheme, address = parts[0], parts[1]
+		}
+		config.Address = address
+	}
+	setString(&config.Datacenter, "consul.datacenter")
+	token, err := clientToken("consul")
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		config.Token = token
+	}
+	setString(&config.TLSConfig.CAFile, "consul.ca_cert")
+	setString(&config.TLSConfig.CAPath, "consul.ca_path")
+	setString(&config.TLSConfig.CertFile, "consul.client_cert")
+	setString(&config.TLSConfig.KeyFile, "consul.client_key")
+	setString(&config.TLSConfig.Address, "consul.tls_server_name")
+	if viper.GetBool("consul.tls_skip_verify") {
+		config.TLSConfig.InsecureSkipVerify = true
+	}
+	return config, nil
+}
+
+// monitorConsulClient returns the Consul client used to record a job
+// deployed from the given job key, check the services of canaries while
+// they soak and await approval of their promotion, or nil if there is no
+// job key and canaries are neither soaked nor approved
+func monitorConsulClient(jobKey string) (*consul.Client, error) {
+	if jobKey == "" && viper.GetDuration("deploy.canary_soak") <= 0 && !viper.GetBool("deploy.require_approval") {
+		return nil, nil
+	}
+	return consulClient()
+}
+
+// clientToken returns the ACL token of the "nomad" or "consul" settings,
+// given by the "token" setting, the environment variable named by the
+// "token_env" setting, or the file given by the "token_file" setting
+func clientToken(client string) (string, error) {
+	if token := viper.GetString(client + ".token"); token != "" {
+		return token, nil
+	}
+	if env := viper.GetString(client + ".token_env"); env != "" {
+		if token := os.Getenv(env); token != "" {
+			return token, nil
+		}
+	}
+	if path := viper.GetString(client + ".token_file"); path != "" {
+		path, err := homedir.Expand(path)
+		if err != nil {
+			return "", err
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to read %s token", client)
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	return "", nil
+}
+
+// setString sets the given string to the value of a viper key, if not empty
+func setString(s *string, key string) {
+	if value := viper.GetString(key); value != "" {
+		*s = value
+	}
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/bdclark/nomadctl/deploy"
+	"github.com/bdclark/nomadctl/logging"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// interruptContext returns a context for monitoring deployments that is
+// interrupted on the first SIGINT or SIGTERM. When running interactively
+// (and the "on-interrupt" flag is not set) the user is asked whether to
+// detach, fail or revert, otherwise the "deploy.on_interrupt" setting is
+// used. A second signal exits immediately.
+func interruptContext(cmd *cobra.Command) context.Context {
+	policy, err := deploy.ParseInterruptAction(viper.GetString("deploy.on_interrupt"))
+	if err != nil {
+		usageError(cmd, err.Error())
+	}
+
+	ask := terminal.IsTerminal(int(os.Stdin.Fd()))
+	if f := cmd.Flags().Lookup("on-interrupt"); f != nil && f.Changed {
+		ask = false
+	}
+
+	ctx, interrupt := deploy.WithInterrupt(context.Background())
+
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-sigCh
+		logging.Warning("received %s, press Ctrl-C again to exit immediately", sig)
+
+		go func() {
+			<-sigCh
+			bail(fmt.Errorf("received second signal, exiting immediately"), 1)
+		}()
+
+		action := policy
+		if ask {
+			choices := make([]string, 0, len(deploy.InterruptActions))
+			for _, a := range deploy.InterruptActions {
+				choices = append(choices, string(a))
+			}
+			action = deploy.InterruptAction(askForChoice("Detach from, fail, or revert the deployment?", choices))
+		}
+
+		interrupt(action)
+	}()
+
+	return ctx
+}
@@ -34,7 +34,9 @@ configuration to be displayed. Use "{{ .Key }}" for the job name and
 promotion setting for every job.`,
 	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		initConfig(cmd)
+		if err := initConfig(cmd); err != nil {
+			bail(err, 1)
+		}
 
 		// set prefix
 		prefix := viper.GetString("prefix")
@@ -46,7 +48,7 @@ promotion setting for every job.`,
 		}
 
 		// get KV list from Consul
-		client, err := consul.NewClient(consul.DefaultConfig())
+		client, err := consulClient()
 		if err != nil {
 			bail(err, 1)
 		}
@@ -109,7 +111,9 @@ example, "prefix" is set to "nomad/jobs" in a config file, then
 key "nomad/jobs/myjob/deploy/auto_promote".`,
 	Args: cobra.ExactArgs(3),
 	Run: func(cmd *cobra.Command, args []string) {
-		initConfig(cmd)
+		if err := initConfig(cmd); err != nil {
+			bail(err, 1)
+		}
 
 		subkey := strings.TrimPrefix(args[1], "/")
 		key := fmt.Sprintf("%s/%s", canonicalizeJobKey(args[0]), subkey)
@@ -125,7 +129,7 @@ key "nomad/jobs/myjob/deploy/auto_promote".`,
 
 		logging.Debug("writing value \"%s\" to key \"%s\"", value, key)
 
-		client, err := consul.NewClient(consul.DefaultConfig())
+		client, err := consulClient()
 		if err != nil {
 			bail(err, 1)
 		}
@@ -160,12 +164,14 @@ example, "prefix" is set to "nomad/jobs" in a config file, then
 key "nomad/jobs/myjob/deploy/auto_promote".`,
 	Args: cobra.ExactArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
-		initConfig(cmd)
+		if err := initConfig(cmd); err != nil {
+			bail(err, 1)
+		}
 
 		subkey := strings.TrimPrefix(args[1], "/")
 		key := fmt.Sprintf("%s/%s", canonicalizeJobKey(args[0]), subkey)
 
-		client, err := consul.NewClient(consul.DefaultConfig())
+		client, err := consulClient()
 		if err != nil {
 			bail(err, 1)
 		}
@@ -1,12 +1,21 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
 
 	"github.com/bdclark/nomadctl/deploy"
+	"github.com/bdclark/nomadctl/logging"
+	"github.com/bdclark/nomadctl/ops"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	yaml "gopkg.in/yaml.v2"
 )
 
 var planCmd = &cobra.Command{
@@ -32,11 +41,23 @@ is submitted.
 See "nomadctl help render template" for details regarding the template
 source and template rendering options.
 
-Once rendered, the plan is executed and shown as standard output.
-Display options can be set with command-line flags.`,
+Once rendered, the job is prepared exactly as "deploy" would register it,
+so by default the count within each task group is updated to match that
+of a remote job with the same name. Use the "force-count" flag to plan
+with the count(s) defined in the job template instead.
+
+The plan is then executed and shown as standard output.
+Display options can be set with command-line flags. Use the "format"
+flag to output the plan as "json" for tooling, or as "markdown" for
+posting as a pull request comment, instead of colorized "text".
+
+Use the "out" flag to save the plan to a file, which can later be
+deployed exactly as planned with "nomadctl deploy --plan-file".`,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		initConfig(cmd)
+		if err := initConfig(cmd); err != nil {
+			bail(err, 255)
+		}
 		viper.Set("template.source", args[0])
 		doPlan(cmd, "")
 	},
@@ -57,19 +78,74 @@ file setting or environment variable, the the actual JOBKEY becomes
 See "nomadctl help render kv" for details regarding the template source,
 rendering options, and supported Consul keys.
 
-Once rendered, unless the "quiet" command-line flag is specified, a
+Once rendered, the job is prepared exactly as "deploy" would register it,
+so by default the count within each task group is updated to match that
+of a remote job with the same name. Use the "force-count" flag or the
+"${JOBKEY}/deploy/force_count" Consul key to plan with the count(s)
+defined in the job template instead.
+
+Unless the "quiet" command-line flag is specified, a
 structured diff between the local and remote job is displayed to give
-insight into what the scheduler will attempt to do and why.
+insight into what the scheduler will attempt to do and why. Use the
+"format" flag to output the plan as "json" for tooling, or as "markdown"
+for posting as a pull request comment, instead of colorized "text".
+
+Use the "out" flag to save the plan to a file, which can later be
+deployed exactly as planned with "nomadctl deploy --plan-file". The
+file contains the rendered job, the remote job's modify index, the
+JOBKEY and template source, the resolved deploy and template settings (without
+template options), and a hash of the job diff.
+
+A plan policy can be used to fail the plan on specific conditions. The
+policy is read from the file given with the "policy" flag or the
+"plan.policy_file" config setting, or from the "${JOBKEY}/plan/policy"
+Consul key or the "plan.policy" config setting. For example:
+
+rules:
+  - name: no-destructive-web-updates
+    condition: destructive_update
+    groups: ["web"]
+    exit_code: 10
+  - condition: count_decrease
+
+Supported conditions are "destructive_update", "count_decrease",
+"placement_failure" (each optionally limited to specific "groups") and
+"image_change_without_meta", which matches a task's "image" config
+changing without any job, group or task meta changing with it. Rules
+without an "exit_code" are given one based on their position in the
+policy, starting at 2. Each violation is logged, and the exit code of
+the first violated rule is returned.
+
+Use the "all" flag to plan every job under a PREFIX instead of a single
+JOBKEY. If PREFIX is not specified, the configured prefix is used. Up to
+"parallel" jobs are planned at once, optionally only those whose key
+matches the "match" glob. Each job's plan is displayed in turn, followed
+by a summary of the results, as a markdown table with "--format markdown".
+With "--format json", a single JSON object keyed by job name holds each
+job's result, plan and error instead. Plan policies are not checked, and
+the "out" and "policy" flags are not supported with "all".
 
 One of the following exit codes will be returned:
 * 0: No allocations created or destroyed.
 * 1: Allocations created or destroyed.
+* 2-254: The plan policy rule with this exit code was violated.
 * 255: Error determining plan results.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		if len(args) != 1 {
-			usageError(cmd, fmt.Sprintf("accepts 1 arg, received %v", len(args)), 255)
+		if err := batchArgs(cmd, args); err != nil {
+			usageError(cmd, err.Error(), 255)
+		}
+		if err := initConfig(cmd); err != nil {
+			bail(err, 255)
+		}
+		if all, _ := cmd.Flags().GetBool("all"); all {
+			for _, flag := range []string{"out", "policy"} {
+				if cmd.Flags().Changed(flag) {
+					usageError(cmd, fmt.Sprintf("the \"%s\" flag is not supported with \"all\"", flag), 255)
+				}
+			}
+			doPlanAll(cmd, args)
+			return
 		}
-		initConfig(cmd)
 		doPlan(cmd, args[0])
 	},
 }
@@ -83,27 +159,243 @@ func init() {
 
 	addConsulFlags(planKVCmd)
 	addPlanFlags(planKVCmd)
+	addBatchFlags(planKVCmd)
+}
+
+// loadPolicy returns the plan policy, if any. A policy file set with the
+// "policy" flag takes precedence over a policy set in Consul or the config
+// file, which in turn takes precedence over a policy file set in the config.
+func loadPolicy(cmd *cobra.Command) (*deploy.Policy, error) {
+	var doc []byte
+
+	if f := cmd.Flags().Lookup("policy"); (f == nil || !f.Changed) && viper.IsSet("plan.policy") {
+		switch p := viper.Get("plan.policy").(type) {
+		case string:
+			doc = []byte(p)
+		default:
+			// policy defined inline in the config file
+			b, err := yaml.Marshal(p)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to read plan policy from config")
+			}
+			doc = b
+		}
+	} else if path := viper.GetString("plan.policy_file"); path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read plan policy")
+		}
+		doc = b
+	}
+
+	if len(doc) == 0 {
+		return nil, nil
+	}
+	return deploy.ParsePolicy(doc)
 }
 
 func doPlan(cmd *cobra.Command, consulJobKey string) {
 	// render template (and set related consul config if applicable)
 	jobspec := doRender(cmd, consulJobKey, 255)
 
-	// create new deployment
-	deployment, err := deploy.NewDeployment(&deploy.NewDeploymentInput{Jobspec: &jobspec})
+	format, err := deploy.ParsePlanFormat(viper.GetString("plan.format"))
+	if err != nil {
+		usageError(cmd, err.Error(), 255)
+	}
+
+	policy, err := loadPolicy(cmd)
+	if err != nil {
+		bail(err, 255)
+	}
+
+	client, err := nomadClient()
 	if err != nil {
 		bail(err, 255)
 	}
 
 	// run a deployment plan
-	changes, err := deployment.Plan(viper.GetBool("plan.quiet"), viper.GetBool("plan.verbose"),
-		viper.GetBool("plan.diff"), viper.GetBool("plan.no_color"))
+	result, err := ops.Plan(context.Background(), client, &ops.PlanOptions{
+		Jobspec:          jobspec,
+		UseTemplateCount: viper.GetBool("deploy.force_count"),
+		Policy:           policy,
+		Output: &deploy.PlanInput{
+			Quiet:   viper.GetBool("plan.quiet"),
+			Verbose: viper.GetBool("plan.verbose"),
+			Diff:    viper.GetBool("plan.diff"),
+			NoColor: viper.GetBool("plan.no_color"),
+			Format:  format,
+		},
+	})
 	if err != nil {
 		bail(err, 255)
 	}
 
+	// save the plan if specified
+	if out, _ := cmd.Flags().GetString("out"); out != "" {
+		plan, err := result.Deployment.NewPlanFile(deployedJobKey(consulJobKey), viper.GetString("template.source"), jobspec, planFileConfig())
+		if err != nil {
+			bail(err, 255)
+		}
+		if err := plan.Write(out); err != nil {
+			bail(err, 255)
+		}
+	}
+
+	// exit with the code of the first violated policy rule, if any
+	if policy != nil {
+		for _, v := range result.Violations {
+			logging.Error("plan policy rule \"%s\" violated: %s", v.Rule.Name, v.Message)
+		}
+		if code := policy.ExitCode(result.Violations); code != 0 {
+			os.Exit(code)
+		}
+	}
+
 	// exit non-zero if allocation changes
-	if changes {
+	if result.Changes {
 		os.Exit(1)
 	}
 }
+
+// planFileTemplateSettings are the template settings saved in a plan
+// file. Template options are not saved, since they can hold getter
+// credentials such as SSH keys.
+var planFileTemplateSettings = []string{
+	"source",
+	"left_delimiter",
+	"right_delimiter",
+	"error_on_missing_key",
+}
+
+// planFileConfig returns the resolved config to save in a plan file. Only
+// the deploy settings and the template settings above are saved, so that
+// secrets such as tokens and credentials are never written to the file.
+func planFileConfig() map[string]interface{} {
+	settings := make(map[string]interface{})
+	for _, key := range viper.AllKeys() {
+		if strings.HasPrefix(key, "deploy.") {
+			settings[strings.TrimPrefix(key, "deploy.")] = viper.Get(key)
+		}
+	}
+
+	template := make(map[string]interface{})
+	for _, key := range planFileTemplateSettings {
+		template[key] = viper.Get("template." + key)
+	}
+
+	return map[string]interface{}{
+		"deploy":   settings,
+		"template": template,
+	}
+}
+
+// batchPlanResult is the JSON result of planning one job of a batch
+type batchPlanResult struct {
+	Result string          `json:"result"`
+	Plan   json.RawMessage `json:"plan,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// printBatchPlansJSON prints the result, plan and error of every job of a
+// batch as a single JSON object keyed by job name
+func printBatchPlansJSON(results []*deploy.BatchResult, output map[string]*bytes.Buffer) error {
+	plans := make(map[string]*batchPlanResult)
+	for _, r := range results {
+		p := &batchPlanResult{Result: r.Result()}
+		if b, ok := output[r.Name]; ok && b.Len() > 0 {
+			p.Plan = json.RawMessage(b.Bytes())
+		}
+		if r.Err != nil {
+			p.Error = r.Err.Error()
+		}
+		plans[r.Name] = p
+	}
+
+	b, err := json.MarshalIndent(plans, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode plans")
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+// doPlanAll plans every job under a prefix
+func doPlanAll(cmd *cobra.Command, args []string) {
+	specs, err := allJobSpecs(cmd, args)
+	if err != nil {
+		bail(err, 255)
+	}
+
+	continueOnError, _ := cmd.Flags().GetBool("continue-on-error")
+	parallel, _ := cmd.Flags().GetInt("parallel")
+
+	jobs, failed, err := renderBatchJobs(cmd, specs, continueOnError)
+	if err != nil {
+		bail(err, 255)
+	}
+
+	format, err := deploy.ParsePlanFormat(viper.GetString("plan.format"))
+	if err != nil {
+		usageError(cmd, err.Error(), 255)
+	}
+
+	// buffer each plan's output so plans run at once aren't interleaved
+	output := make(map[string]*bytes.Buffer)
+	for _, j := range jobs {
+		output[j.Name] = &bytes.Buffer{}
+		j.Plan = &deploy.PlanInput{
+			Quiet:   viper.GetBool("plan.quiet"),
+			Verbose: viper.GetBool("plan.verbose"),
+			Diff:    viper.GetBool("plan.diff"),
+			NoColor: viper.GetBool("plan.no_color"),
+			Format:  format,
+			Out:     output[j.Name],
+		}
+	}
+
+	batch, err := deploy.NewBatch(jobs)
+	if err != nil {
+		bail(err, 255)
+	}
+
+	results := batch.Run(context.Background(), &deploy.BatchRunInput{
+		Parallel:    parallel,
+		StopOnError: !continueOnError,
+	})
+
+	results = append(failed, results...)
+
+	switch format {
+	case deploy.PlanFormatJSON:
+		if err := printBatchPlansJSON(results, output); err != nil {
+			bail(err, 255)
+		}
+	default:
+		for _, j := range jobs {
+			if output[j.Name].Len() == 0 {
+				continue
+			}
+			if format == deploy.PlanFormatText {
+				fmt.Printf("==> Job \"%s\"\n", j.Name)
+			}
+			output[j.Name].WriteTo(os.Stdout)
+			fmt.Println()
+		}
+		if format == deploy.PlanFormatMarkdown {
+			deploy.PrintBatchResultsMarkdown(results)
+		} else {
+			deploy.PrintBatchResults(results)
+		}
+	}
+
+	code := 0
+	for _, r := range results {
+		switch {
+		case !r.Success:
+			code = 255
+		case r.Changes && code == 0:
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
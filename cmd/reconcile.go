@@ -0,0 +1,252 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bdclark/nomadctl/deploy"
+	"github.com/bdclark/nomadctl/logging"
+	"github.com/hashicorp/nomad/api"
+	"github.com/hashicorp/nomad/nomad/structs"
+	"github.com/spf13/cobra"
+)
+
+var reconcileCmd = &cobra.Command{
+	Use:   "reconcile [PREFIX]",
+	Short: "Find Nomad jobs and job keys without a counterpart",
+	Long: `Cross-references the jobs running in Nomad with the job keys stored
+in Consul under PREFIX (or the configured prefix), and reports orphans on
+both sides: Nomad jobs that no longer have a job key, and job keys whose
+job is not running in Nomad.
+
+Each job key is rendered to determine the name of its job, see "nomadctl
+help render kv". Only Nomad jobs deployed by nomadctl from a job key under
+PREFIX are considered, as recorded in Consul under
+"${PREFIX}/.nomadctl/jobs" (see "nomadctl help deploy kv"), so jobs
+deployed by other means or from other prefixes are never reported as
+orphans. Child jobs of periodic and parameterized jobs, and jobs matching
+an "ignore" glob, are never reported either.
+
+Use the "prune" flag to stop the orphaned Nomad jobs, and the "purge" flag
+to also purge them from Nomad. A plan of the allocations each job stops is
+displayed and confirmation is requested unless the "yes" flag is set. Jobs
+are never pruned if any job key fails to render, since its job would
+otherwise appear orphaned.
+
+One of the following exit codes will be returned:
+* 0: No orphans found (or all orphaned Nomad jobs were pruned).
+* 1: Orphans found.
+* 255: Error reconciling jobs.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := initConfig(cmd); err != nil {
+			bail(err, 255)
+		}
+
+		purge, _ := cmd.Flags().GetBool("purge")
+		prune, _ := cmd.Flags().GetBool("prune")
+		prune = prune || purge
+		ignore, _ := cmd.Flags().GetStringSlice("ignore")
+		for _, glob := range ignore {
+			if _, err := path.Match(glob, ""); err != nil {
+				usageError(cmd, fmt.Sprintf("invalid ignore pattern \"%s\"", glob), 255)
+			}
+		}
+
+		prefix, err := jobPrefix(args)
+		if err != nil {
+			usageError(cmd, err.Error(), 255)
+		}
+
+		// render every job key to find the job it defines
+		specs, err := allJobSpecs(cmd, args)
+		if err != nil {
+			bail(err, 255)
+		}
+		jobs, failed, err := renderBatchJobs(cmd, specs, true)
+		if err != nil {
+			bail(err, 255)
+		}
+
+		keysByJob := make(map[string]string)
+		for _, j := range jobs {
+			keysByJob[j.Deployment.JobID()] = j.Name
+		}
+
+		// jobs recorded as deployed from a job key under the prefix
+		consul, err := consulClient()
+		if err != nil {
+			bail(err, 255)
+		}
+		recordsKey := deploy.DeployedJobsKey(prefix) + "/"
+		records, _, err := consul.KV().List(recordsKey, nil)
+		if err != nil {
+			bail(err, 255)
+		}
+		deployedFrom := make(map[string]string)
+		for _, pair := range records {
+			deployedFrom[strings.TrimPrefix(pair.Key, recordsKey)] = string(pair.Value)
+		}
+
+		client, err := nomadClient()
+		if err != nil {
+			bail(err, 255)
+		}
+		stubs, _, err := client.Jobs().List(nil)
+		if err != nil {
+			bail(err, 255)
+		}
+
+		// nomad jobs deployed from a key under the prefix that no longer renders them
+		var orphanJobs []*orphanJob
+		running := make(map[string]*api.JobListStub)
+		for _, stub := range stubs {
+			if stub.ParentID != "" {
+				continue
+			}
+			if stub.Status != structs.JobStatusDead {
+				running[stub.ID] = stub
+			}
+			if _, ok := keysByJob[stub.ID]; ok || stub.Status == structs.JobStatusDead || ignored(stub.ID, ignore) {
+				continue
+			}
+
+			key, ok := deployedFrom[stub.ID]
+			if !ok {
+				logging.Debug("ignoring job \"%s\", not deployed from a job key under \"%s\"", stub.ID, prefix)
+				continue
+			}
+			job, _, err := client.Jobs().Info(stub.ID, nil)
+			if err != nil {
+				bail(err, 255)
+			}
+			orphanJobs = append(orphanJobs, &orphanJob{stub: stub, job: job, key: key})
+		}
+
+		// job keys without a running job
+		var orphanKeys []string
+		for jobID, key := range keysByJob {
+			if _, ok := running[jobID]; !ok {
+				orphanKeys = append(orphanKeys, fmt.Sprintf("%s\t%s", key, jobID))
+			}
+		}
+		sort.Strings(orphanKeys)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		if len(orphanJobs) > 0 {
+			fmt.Fprintln(w, "Nomad jobs without a job key:")
+			fmt.Fprintln(w, "Job\tDeployed From\tType\tStatus\tRunning Allocs")
+			for _, o := range orphanJobs {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", o.stub.ID, o.key, o.stub.Type, o.stub.Status, runningAllocs(o.stub))
+			}
+			fmt.Fprintln(w)
+		}
+		if len(orphanKeys) > 0 {
+			fmt.Fprintln(w, "Job keys without a running job:")
+			fmt.Fprintln(w, "Key\tJob")
+			for _, row := range orphanKeys {
+				fmt.Fprintln(w, row)
+			}
+			fmt.Fprintln(w)
+		}
+		w.Flush()
+
+		if len(orphanJobs) == 0 && len(orphanKeys) == 0 && len(failed) == 0 {
+			fmt.Fprintln(os.Stderr, "No orphans found.")
+			os.Exit(0)
+		}
+
+		if !prune || len(orphanJobs) == 0 {
+			if len(failed) > 0 {
+				os.Exit(255)
+			}
+			os.Exit(1)
+		}
+
+		if len(failed) > 0 {
+			bail(fmt.Errorf("not pruning jobs, %d job key(s) failed to render", len(failed)), 255)
+		}
+
+		action := "stop"
+		if purge {
+			action = "stop and purge"
+		}
+
+		// show what stopping each job changes before asking to
+		fmt.Printf("Plan to %s %d job(s):\n\n", action, len(orphanJobs))
+		for _, o := range orphanJobs {
+			deploy.WriteStopPlan(os.Stdout, o.job, o.stub.JobSummary)
+			fmt.Println()
+		}
+
+		if skip, _ := cmd.Flags().GetBool("yes"); !skip {
+			if confirm := askForConfirmation(fmt.Sprintf("%s the %d Nomad job(s) without a job key?", action, len(orphanJobs))); !confirm {
+				fmt.Fprintln(os.Stderr, "Abandoning prune.")
+				os.Exit(1)
+			}
+		}
+
+		code := 0
+		for _, o := range orphanJobs {
+			evalID, _, err := client.Jobs().Deregister(o.stub.ID, purge, nil)
+			if err != nil {
+				logging.Error("failed to stop job \"%s\": %v", o.stub.ID, err)
+				code = 255
+				continue
+			}
+			logging.Info("stopped job \"%s\", evaluation \"%s\"", o.stub.ID, evalID)
+			if _, err := consul.KV().Delete(recordsKey+o.stub.ID, nil); err != nil {
+				logging.Warning("failed to delete the record of job \"%s\": %v", o.stub.ID, err)
+			}
+		}
+
+		if code == 0 && len(orphanKeys) > 0 {
+			code = 1
+		}
+		os.Exit(code)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reconcileCmd)
+
+	addConfigFlags(reconcileCmd)
+	addConsulFlags(reconcileCmd)
+	reconcileCmd.Flags().Bool("prune", false, "stop Nomad jobs without a job key")
+	reconcileCmd.Flags().Bool("purge", false, "prune, and also purge the pruned jobs from Nomad")
+	reconcileCmd.Flags().StringSlice("ignore", []string{}, "glob of Nomad jobs to ignore (can be supplied multiple times)")
+	reconcileCmd.Flags().Bool("yes", false, "skips asking for confirmation before pruning")
+}
+
+// orphanJob is a Nomad job deployed from a job key that no longer renders it
+type orphanJob struct {
+	stub *api.JobListStub
+	job  *api.Job
+	key  string // the job key the job was deployed from
+}
+
+// ignored returns true if the job ID matches any of the globs
+func ignored(jobID string, globs []string) bool {
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, jobID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// runningAllocs returns the number of running allocations of a job
+func runningAllocs(stub *api.JobListStub) int {
+	if stub.JobSummary == nil {
+		return 0
+	}
+	n := 0
+	for _, tg := range stub.JobSummary.Summary {
+		n += tg.Running
+	}
+	return n
+}
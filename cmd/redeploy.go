@@ -1,7 +1,7 @@
 package cmd
 
 import (
-	"github.com/bdclark/nomadctl/deploy"
+	"github.com/bdclark/nomadctl/ops"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -18,19 +18,59 @@ the updated job.
 
 Normal job update settings apply, including canaries. If canaries 
 are configured, you can use the "--auto-promote" flag to automatically
-promote the deployment after the canary(s) are healthy.`,
+promote the deployment after the canary(s) are healthy.
+
+Use the "--timeout" flag to fail the deployment if it is not complete
+within the given duration, and the "--auto-revert" flag to then revert
+the job to its last stable version.
+
+Use the "--json" flag to print the result of the re-deployment as JSON
+once it is complete, the same as "nomadctl deploy --json".`,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		initConfig(cmd)
+		if err := initConfig(cmd); err != nil {
+			bail(err, 1)
+		}
 
 		groups, _ := cmd.Flags().GetStringSlice("group")
 
-		_, err := deploy.ReDeploy(&deploy.RedeploymentInput{
-			JobName:        args[0],
-			TaskGroupNames: groups,
+		onFailure, err := failureAction()
+		if err != nil {
+			usageError(cmd, err.Error())
+		}
+		order, err := promoteOrder()
+		if err != nil {
+			usageError(cmd, err.Error())
+		}
+		if _, err := approvalKey(""); err != nil {
+			usageError(cmd, err.Error())
+		}
+
+		client, err := nomadClient()
+		if err != nil {
+			bail(err, 1)
+		}
+		monitorClient, err := monitorConsulClient("")
+		if err != nil {
+			bail(err, 1)
+		}
+
+		result, err := ops.Redeploy(interruptContext(cmd), client, &ops.RedeployOptions{
+			Job:            args[0],
+			Groups:         groups,
 			AutoPromote:    viper.GetBool("deploy.auto_promote"),
-			Verbose:        false,
+			CanarySoak:     viper.GetDuration("deploy.canary_soak"),
+			ConsulClient:   monitorClient,
+			PromoteOrder:   order,
+			PromotePause:   viper.GetDuration("deploy.promote_pause"),
+			CanaryAnalysis: canaryAnalysis(),
+			Timeout:        viper.GetDuration("deploy.timeout"),
+			AutoRevert:     viper.GetBool("deploy.auto_revert"),
+			OnFailure:      onFailure,
 		})
+		if result != nil {
+			printDeployResult(cmd, result.Deployment, result.Success, err)
+		}
 		if err != nil {
 			bail(err, 1)
 		}
@@ -43,4 +83,6 @@ func init() {
 	addConfigFlags(redeployCmd)
 	redeployCmd.Flags().Bool("auto-promote", false, "automatically promote canary deployment")
 	redeployCmd.Flags().StringSlice("group", []string{}, "group to redeploy (can be supplied multiple times)")
+	redeployCmd.Flags().Bool("json", false, "print the result of the monitored deployment as JSON, including any canary analysis")
+	addMonitorFlags(redeployCmd)
 }
@@ -1,11 +1,11 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 
-	"github.com/bdclark/nomadctl/template"
-	consul "github.com/hashicorp/consul/api"
+	"github.com/bdclark/nomadctl/ops"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -37,7 +37,9 @@ delimiters of "{{" and "}}", but can be overridden with command-line
 flags or config file settings.`,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		initConfig(cmd)
+		if err := initConfig(cmd); err != nil {
+			bail(err, 1)
+		}
 		viper.Set("template.source", args[0])
 		fmt.Fprintf(os.Stdout, "%s", doRender(cmd, "", 1))
 	},
@@ -86,7 +88,9 @@ However, if a command-line flag is specified, it overrides the related
 setting found in Consul.`,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		initConfig(cmd)
+		if err := initConfig(cmd); err != nil {
+			bail(err, 1)
+		}
 		fmt.Fprintf(os.Stdout, "%s", doRender(cmd, args[0], 1))
 	},
 }
@@ -105,18 +109,30 @@ func init() {
 }
 
 func doRender(cmd *cobra.Command, consulJobKey string, failCode int) []byte {
-	initConfig(cmd)
+	if err := initConfig(cmd); err != nil {
+		bail(err, failCode)
+	}
+
+	output, err := renderJob(cmd, consulJobKey)
+	if err != nil {
+		bail(err, failCode)
+	}
+	return output
+}
 
+// renderJob renders a job template using the current config, after
+// updating the config from Consul if a job key is given
+func renderJob(cmd *cobra.Command, consulJobKey string) ([]byte, error) {
 	// update viper settings from Consul
 	if consulJobKey != "" {
-		client, err := consul.NewClient(consul.DefaultConfig())
+		client, err := consulClient()
 		if err != nil {
-			bail(err, failCode)
+			return nil, err
 		}
 
 		err = setConfigFromKV(cmd, client, consulJobKey)
 		if err != nil {
-			bail(err, failCode)
+			return nil, err
 		}
 
 		if k := canonicalizeJobKey(consulJobKey); consulJobKey != "" && k != "" {
@@ -126,26 +142,18 @@ func doRender(cmd *cobra.Command, consulJobKey string, failCode int) []byte {
 		}
 	}
 
-	// set template input from viper
-	config := &template.NewTemplateInput{
+	// render template with input from viper
+	config, err := consulConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return ops.Render(context.Background(), config, &ops.RenderOptions{
 		Source:        viper.GetString("template.source"),
 		Contents:      viper.GetString("template.contents"),
 		LeftDelim:     viper.GetString("template.left_delimiter"),
 		RightDelim:    viper.GetString("template.right_delimiter"),
 		ErrMissingKey: viper.GetBool("template.error_on_missing_key"),
 		Options:       viper.GetStringMapString("template.options"),
-	}
-
-	// create template
-	template, err := template.NewTemplate(config)
-	if err != nil {
-		bail(err, failCode)
-	}
-
-	// render template
-	output, err := template.Render()
-	if err != nil {
-		bail(err, failCode)
-	}
-	return output
+	})
 }
@@ -1,10 +1,11 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 
-	"github.com/bdclark/nomadctl/nomad"
+	"github.com/bdclark/nomadctl/ops"
 	"github.com/spf13/cobra"
 )
 
@@ -15,22 +16,19 @@ var restartCmd = &cobra.Command{
 	Long:  `Restarts a Nomad job or a task group within a job if specified.`,
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
+		if err := initConfig(cmd); err != nil {
+			bail(err, 1)
+		}
 
 		group, _ := cmd.Flags().GetString("group")
 
-		nomad, err := nomad.NewNomadClient(nil)
+		client, err := nomadClient()
 		if err != nil {
 			bail(err, 1)
 		}
 
-		if group == "" {
-			if err := nomad.RestartJob(args[0]); err != nil {
-				bail(err, 1)
-			}
-		} else {
-			if err := nomad.RestartTaskGroup(args[0], group); err != nil {
-				bail(err, 1)
-			}
+		if err := ops.Restart(context.Background(), client, &ops.RestartOptions{Job: args[0], Group: group}); err != nil {
+			bail(err, 1)
 		}
 
 		fmt.Fprintln(os.Stderr, "Done")
@@ -40,5 +38,7 @@ var restartCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(restartCmd)
 
+	addConfigFlags(restartCmd)
+
 	restartCmd.Flags().String("group", "", "Task group to restart rather than entire job")
 }
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bdclark/nomadctl/deploy"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback JOB",
+	Short: "Roll back a job to a previous version",
+	Long: `Rolls back an existing Nomad job to one of its previous versions.
+
+The job's versions are listed along with whether Nomad marked each
+version as stable. By default the job is rolled back to the version
+prior to the current version. Use the "--version" flag to roll back to
+a specific version, or the "--last-stable" flag to roll back to the most
+recent stable version.
+
+Before reverting, a diff between the current job and the target version
+is displayed and confirmation is requested unless the "--yes" flag is set.
+Once reverted, the resulting deployment is monitored the same way as the
+"deploy" command, including canary auto-promotion and timeouts.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := initConfig(cmd); err != nil {
+			bail(err, 1)
+		}
+
+		lastStable, _ := cmd.Flags().GetBool("last-stable")
+
+		var version *uint64
+		if cmd.Flags().Changed("version") {
+			if lastStable {
+				usageError(cmd, "--version and --last-stable are mutually exclusive")
+			}
+			v, _ := cmd.Flags().GetUint64("version")
+			version = &v
+		}
+
+		onFailure, err := failureAction()
+		if err != nil {
+			usageError(cmd, err.Error())
+		}
+		order, err := promoteOrder()
+		if err != nil {
+			usageError(cmd, err.Error())
+		}
+		if _, err := approvalKey(""); err != nil {
+			usageError(cmd, err.Error())
+		}
+
+		client, err := nomadClient()
+		if err != nil {
+			bail(err, 1)
+		}
+		monitorClient, err := monitorConsulClient("")
+		if err != nil {
+			bail(err, 1)
+		}
+
+		deployment, err := deploy.NewRollback(&deploy.RollbackInput{
+			Client:         client,
+			JobName:        args[0],
+			Version:        version,
+			LastStable:     lastStable,
+			AutoPromote:    viper.GetBool("deploy.auto_promote"),
+			CanarySoak:     viper.GetDuration("deploy.canary_soak"),
+			ConsulClient:   monitorClient,
+			PromoteOrder:   order,
+			PromotePause:   viper.GetDuration("deploy.promote_pause"),
+			CanaryAnalysis: canaryAnalysis(),
+			Timeout:        viper.GetDuration("deploy.timeout"),
+			AutoRevert:     viper.GetBool("deploy.auto_revert"),
+			OnFailure:      onFailure,
+			Verbose:        false,
+		})
+		if err != nil {
+			bail(err, 1)
+		}
+
+		deployment.PrintVersions()
+
+		if _, err := deployment.Plan(&deploy.PlanInput{Diff: true}); err != nil {
+			bail(err, 1)
+		}
+
+		if force, _ := cmd.Flags().GetBool("yes"); !force {
+			if confirm := askForConfirmation("Continue rollback?"); !confirm {
+				fmt.Fprintln(os.Stderr, "Abandoning rollback.")
+				os.Exit(0)
+			}
+		}
+
+		if _, err := deployment.Deploy(interruptContext(cmd)); err != nil {
+			bail(err, 1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rollbackCmd)
+
+	addConfigFlags(rollbackCmd)
+	addMonitorFlags(rollbackCmd)
+	rollbackCmd.Flags().Uint64("version", 0, "job version to roll back to")
+	rollbackCmd.Flags().Bool("last-stable", false, "roll back to the last stable job version")
+	rollbackCmd.Flags().Bool("auto-promote", false, "automatically promote canary deployment")
+	rollbackCmd.Flags().Bool("yes", false, "skips asking for confirmation")
+}
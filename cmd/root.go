@@ -14,11 +14,15 @@ var rootCmd = &cobra.Command{
 	Short: "Nomadctl is a utility to help manage Nomad.",
 	Long: `Nomadctl is a utility to help manage Nomad.
 
-Nomad client settings must be configured via the standard Nomad
-environment variables:
+Nomad client settings can be set with the global "address", "region",
+"namespace", "token", "ca-cert", "ca-path", "client-cert", "client-key",
+"tls-server-name" and "tls-skip-verify" flags, or their equivalent
+"nomad" config settings. Any setting not given falls back to the standard
+Nomad environment variables:
 
 NOMAD_ADDR: The address of the Nomad server, default: http://127.0.0.1:4646.
 NOMAD_REGION: The region of the Nomad server to forward commands to.
+NOMAD_NAMESPACE: The namespace to use.
 NOMAD_CACERT: Path to CA cert file to verify Nomad server SSL cert.
 NOMAD_CAPATH: Path to directory of CA cert files to verify server SSL cert.
 NOMAD_CLIENT_CERT: Path to client cert for TLS authentication to Nomad.
@@ -26,12 +30,23 @@ NOMAD_CLIENT_KEY: Path to an private key matching the client cert.
 NOMAD_SKIP_VERIFY: Do not verify TLS certificate (not recommended).
 NOMAD_TOKEN: The ACL token to use to authenticate API requests.
 
-For any of the "kv" subcommands that query Consul, client settings must
-be configured via the standard Consul environment variables. See
-https://www.consul.io/docs/commands/index.html#environment-variables.`,
+Consul client settings, used by the "kv" sub-commands and when rendering
+templates, can likewise be set with the global "consul-" prefixed flags,
+such as "consul-address" and "consul-token", or their equivalent "consul"
+config settings, falling back to the standard Consul environment
+variables. See
+https://www.consul.io/docs/commands/index.html#environment-variables.
+
+Client settings for multiple clusters can be kept in the config file as
+named contexts, selected with the global "context" flag. See "nomadctl help
+context" for details.`,
 	Version: version.Get(true),
 }
 
+func init() {
+	addClientFlags(rootCmd)
+}
+
 // Execute is called from main and executes the rootCmd
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
@@ -40,6 +55,9 @@ func Execute() {
 	}
 }
 
+// bail prints the error and exits with the given code. It is only for the
+// Run functions of commands: config, render and deploy helpers shared with
+// the server and agent return errors instead, so they never exit the process.
 func bail(err error, code int) {
 	fmt.Fprintln(os.Stderr, err)
 	os.Exit(code)
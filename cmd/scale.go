@@ -1,11 +1,12 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strconv"
 
-	"github.com/bdclark/nomadctl/nomad"
+	"github.com/bdclark/nomadctl/ops"
 	"github.com/spf13/cobra"
 )
 
@@ -22,26 +23,19 @@ var scaleGetCmd = &cobra.Command{
 	Short: "Get the current count of a Nomad task group",
 	Args:  cobra.ExactArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
-		jobName := args[0]
-		tgName := args[1]
-
-		nomad, err := nomad.NewNomadClient(nil)
-		if err != nil {
+		if err := initConfig(cmd); err != nil {
 			bail(err, 1)
 		}
 
-		job, _, err := nomad.Jobs().Info(jobName, nil)
+		client, err := nomadClient()
 		if err != nil {
 			bail(err, 1)
 		}
-
-		for _, tg := range job.TaskGroups {
-			if *tg.Name == tgName {
-				fmt.Fprintln(os.Stdout, *tg.Count)
-				os.Exit(0)
-			}
+		count, err := ops.TaskGroupCount(context.Background(), client, args[0], args[1])
+		if err != nil {
+			bail(err, 1)
 		}
-		bail(fmt.Errorf("could not find task group: %s", tgName), 1)
+		fmt.Fprintln(os.Stdout, count)
 	},
 }
 
@@ -50,13 +44,17 @@ var scaleUpCmd = &cobra.Command{
 	Short: "Scale a task group up by the given count",
 	Args:  cobra.ExactArgs(3),
 	Run: func(cmd *cobra.Command, args []string) {
-		jobName := args[0]
-		tgName := args[1]
+		if err := initConfig(cmd); err != nil {
+			bail(err, 1)
+		}
+
 		delta, err := strconv.Atoi(args[2])
 		if err != nil {
 			bail(err, 1)
 		}
-		scaleAdjust(jobName, tgName, delta)
+		if err := scale(args[0], args[1], delta, true); err != nil {
+			bail(err, 1)
+		}
 	},
 }
 
@@ -64,13 +62,17 @@ var scaleDownCmd = &cobra.Command{
 	Use:   "down JOB GROUP COUNT",
 	Short: "Scale a task group down by the given count",
 	Run: func(cmd *cobra.Command, args []string) {
-		jobName := args[0]
-		tgName := args[1]
+		if err := initConfig(cmd); err != nil {
+			bail(err, 1)
+		}
+
 		delta, err := strconv.Atoi(args[2])
 		if err != nil {
 			bail(err, 1)
 		}
-		scaleAdjust(jobName, tgName, -delta)
+		if err := scale(args[0], args[1], -delta, true); err != nil {
+			bail(err, 1)
+		}
 	},
 }
 
@@ -79,24 +81,15 @@ var scaleSetCmd = &cobra.Command{
 	Short: "Scale a task group to a given count",
 	Args:  cobra.ExactArgs(3),
 	Run: func(cmd *cobra.Command, args []string) {
-		jobName := args[0]
-		tgName := args[1]
-		count, err := strconv.Atoi(args[2])
-		if err != nil {
-			bail(err, 1)
-		}
-
-		nomad, err := nomad.NewNomadClient(nil)
-		if err != nil {
+		if err := initConfig(cmd); err != nil {
 			bail(err, 1)
 		}
 
-		job, _, err := nomad.Jobs().Info(jobName, nil)
+		count, err := strconv.Atoi(args[2])
 		if err != nil {
 			bail(err, 1)
 		}
-
-		if err := nomad.SetTaskGroupCount(job, tgName, count); err != nil {
+		if err := scale(args[0], args[1], count, false); err != nil {
 			bail(err, 1)
 		}
 	},
@@ -108,20 +101,25 @@ func init() {
 	scaleCmd.AddCommand(scaleUpCmd)
 	scaleCmd.AddCommand(scaleDownCmd)
 	scaleCmd.AddCommand(scaleSetCmd)
-}
 
-func scaleAdjust(jobName string, tgName string, delta int) {
-	nomad, err := nomad.NewNomadClient(nil)
-	if err != nil {
-		bail(err, 1)
+	for _, c := range scaleCmd.Commands() {
+		addConfigFlags(c)
 	}
+}
 
-	job, _, err := nomad.Jobs().Info(jobName, nil)
+// scale sets the count of a job's task group, or adjusts
+// its count by the given count if relative is true
+func scale(jobName string, tgName string, count int, relative bool) error {
+	client, err := nomadClient()
 	if err != nil {
-		bail(err, 1)
+		return err
 	}
 
-	if err := nomad.AdjustTaskGroupCount(job, tgName, delta); err != nil {
-		bail(err, 1)
-	}
+	_, err = ops.Scale(context.Background(), client, &ops.ScaleOptions{
+		Job:      jobName,
+		Group:    tgName,
+		Count:    count,
+		Relative: relative,
+	})
+	return err
 }
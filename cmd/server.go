@@ -0,0 +1,732 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bdclark/nomadctl/deploy"
+	"github.com/bdclark/nomadctl/logging"
+	"github.com/bdclark/nomadctl/ops"
+	consul "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/nomad/api"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var serverCmd = &cobra.Command{
+	Use:   "server",
+	Short: "Serve an HTTP API for rendering, planning and deploying jobs",
+	Long: `Runs an HTTP server until interrupted, exposing the render, plan,
+deploy, redeploy, scale and restart operations as JSON endpoints, so that
+tools such as chat bots do not need to run nomadctl themselves.
+
+Every request must include the token set with the "server.token" config
+setting (or NOMADCTL_SERVER_TOKEN environment variable) in an
+"Authorization: Bearer TOKEN" header. The server does not start without
+a token. Requests are plain HTTP, so use a TLS-terminating proxy if the
+"listen" address is not local.
+
+Jobs are given by a Consul "key", see "nomadctl help deploy kv", or by a
+template "source" if the "allow-source" flag is set. Since a source can
+be any local file or URL, only allow sources if every token holder may
+read those. Requests may also include "deploy" settings ("auto_promote",
+"auto_revert", "canary_analysis", "canary_soak", "force_count",
+"on_failure", "promote_order", "promote_pause" and "timeout") overriding
+those in Consul. Deployments of jobs requiring approval (see "nomadctl
+help deploy kv") wait for "nomadctl approve" in the background, and this
+cannot be overridden by requests.
+
+POST /v1/render    {"key": "myapp"}                  renders a job
+POST /v1/plan      {"key": "myapp"}                  plans a job (JSON plan)
+POST /v1/deploy    {"key": "myapp", "deploy": {...}} deploys a job
+POST /v1/redeploy  {"job": "myapp", "groups": [...]} redeploys a job
+POST /v1/scale     {"job": "myapp", "group": "web", "count": 3}
+                   (or "delta": -1) scales a task group
+POST /v1/restart   {"job": "myapp", "group": "web"}  restarts a job or group
+GET  /v1/operations/ID         status of a deployment
+GET  /v1/operations/ID/events  progress of a deployment as Server-Sent Events
+
+Deployments and redeployments are asynchronous: once the job is
+registered, the response (202 Accepted) includes the operation ID along
+with the job's evaluation and deployment IDs, and the deployment is then
+monitored in the background. Its progress is streamed as "log" events,
+followed by a "done" event with the final status. If the deployment's
+canaries were analyzed (see "nomadctl help deploy"), the final status
+includes the report as "canary_analysis". Only one deployment of a job
+runs at a time. Failed requests respond with {"error": "..."}.
+
+When interrupted, running deployments are detached from and the server
+exits.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := initConfig(cmd); err != nil {
+			bail(err, 1)
+		}
+
+		token := viper.GetString("server.token")
+		if token == "" {
+			bail(fmt.Errorf("a token is required, set \"server.token\" or NOMADCTL_SERVER_TOKEN"), 1)
+		}
+
+		client, err := nomadClient()
+		if err != nil {
+			bail(err, 1)
+		}
+
+		s, err := newAPIServer(cmd, client, token)
+		if err != nil {
+			bail(err, 1)
+		}
+		if err := s.run(); err != nil {
+			bail(err, 1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serverCmd)
+
+	addConfigFlags(serverCmd)
+	addConsulFlags(serverCmd)
+	serverCmd.Flags().String("listen", "127.0.0.1:8080", "address to listen on")
+	serverCmd.Flags().Bool("allow-source", false, "allow jobs to be given by template source rather than Consul key")
+}
+
+const (
+	// serverMaxRequestSize is the largest request body accepted
+	serverMaxRequestSize = 1 << 20
+
+	// operationRetention is how long finished operations are kept
+	operationRetention = time.Hour
+)
+
+// apiServer serves the HTTP API
+type apiServer struct {
+	cmd         *cobra.Command
+	client      *api.Client
+	consul      *consul.Client             // checks the canary services of soaking redeployments
+	analysis    *deploy.AnalysisThresholds // the canary analysis thresholds of redeployments
+	token       string
+	allowSource bool
+	ctx         context.Context
+	wg          sync.WaitGroup
+
+	renderMu sync.Mutex // rendering uses the global config, so one job at a time
+
+	mu         sync.Mutex
+	operations map[string]*operation
+	running    map[string]string // job IDs being deployed, to their operation IDs
+}
+
+// newAPIServer returns a server of the given config. Redeployments are not
+// rendered, so settings they use are resolved here rather than per request,
+// where the config may be changed by a render.
+func newAPIServer(cmd *cobra.Command, client *api.Client, token string) (*apiServer, error) {
+	c, err := consulClient()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &apiServer{
+		cmd:        cmd,
+		client:     client,
+		consul:     c,
+		analysis:   analysisThresholds(),
+		token:      token,
+		operations: make(map[string]*operation),
+		running:    make(map[string]string),
+	}
+	s.allowSource, _ = cmd.Flags().GetBool("allow-source")
+	return s, nil
+}
+
+// run serves the API until interrupted
+func (s *apiServer) run() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.ctx = ctx
+
+	listen, _ := s.cmd.Flags().GetString("listen")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/render", s.post(s.handleRender))
+	mux.HandleFunc("/v1/plan", s.post(s.handlePlan))
+	mux.HandleFunc("/v1/deploy", s.post(s.handleDeploy))
+	mux.HandleFunc("/v1/redeploy", s.post(s.handleRedeploy))
+	mux.HandleFunc("/v1/scale", s.post(s.handleScale))
+	mux.HandleFunc("/v1/restart", s.post(s.handleRestart))
+	mux.HandleFunc("/v1/operations/", s.authorized(s.handleOperation))
+	server := &http.Server{Addr: listen, Handler: mux}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigCh
+		logging.Warning("received %s, shutting down", sig)
+		cancel()
+		server.Close()
+	}()
+
+	logging.Info("listening on %s", listen)
+	err := server.ListenAndServe()
+	if err == http.ErrServerClosed {
+		err = nil
+	}
+
+	cancel()
+	s.wg.Wait()
+	return err
+}
+
+// authorized wraps a handler, rejecting requests without the server's token
+func (s *apiServer) authorized(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("invalid or missing token"))
+			return
+		}
+		h(w, r)
+	}
+}
+
+// post wraps an authorized handler that only accepts POST requests
+func (s *apiServer) post(h http.HandlerFunc) http.HandlerFunc {
+	return s.authorized(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, serverMaxRequestSize)
+		h(w, r)
+	})
+}
+
+// jobRequest is the body of render, plan and deploy requests
+type jobRequest struct {
+	Key     string                 `json:"key"`     // Consul job key
+	Source  string                 `json:"source"`  // template source, if allowed
+	Options map[string]string      `json:"options"` // template getter options, with source
+	Deploy  map[string]interface{} `json:"deploy"`  // deploy settings overriding those in Consul
+}
+
+// spec validates the request and returns the spec of its job
+func (s *apiServer) spec(req *jobRequest) (*batchJobSpec, error) {
+	if (req.Key == "") == (req.Source == "") {
+		return nil, fmt.Errorf("exactly one of \"key\" or \"source\" is required")
+	}
+	if req.Source != "" && !s.allowSource {
+		return nil, fmt.Errorf("template sources are not allowed")
+	}
+	for k := range req.Deploy {
+		if _, ok := batchDeployFlags[k]; !ok {
+			return nil, fmt.Errorf("unsupported deploy setting \"%s\"", k)
+		}
+	}
+
+	spec := &batchJobSpec{
+		name:   req.Key + req.Source,
+		key:    req.Key,
+		deploy: req.Deploy,
+	}
+	if req.Source != "" {
+		spec.settings = map[string]interface{}{
+			"template.source":  req.Source,
+			"template.options": req.Options,
+		}
+	}
+	return spec, nil
+}
+
+// render renders the job of a request with the given logger
+func (s *apiServer) render(req *jobRequest, logger *logging.Logger) (*deploy.BatchJob, error) {
+	spec, err := s.spec(req)
+	if err != nil {
+		return nil, err
+	}
+	spec.logger = logger
+
+	s.renderMu.Lock()
+	defer s.renderMu.Unlock()
+	return renderBatchJob(s.cmd, spec, true)
+}
+
+func (s *apiServer) handleRender(w http.ResponseWriter, r *http.Request) {
+	var req jobRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	spec, err := s.spec(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.renderMu.Lock()
+	jobspec, err := renderSpec(s.cmd, spec)
+	s.renderMu.Unlock()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"jobspec": string(jobspec)})
+}
+
+func (s *apiServer) handlePlan(w http.ResponseWriter, r *http.Request) {
+	var req jobRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	job, err := s.render(&req, nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	var out bytes.Buffer
+	if _, err := job.Deployment.Plan(&deploy.PlanInput{Format: deploy.PlanFormatJSON, Out: &out}); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	out.WriteTo(w)
+}
+
+func (s *apiServer) handleDeploy(w http.ResponseWriter, r *http.Request) {
+	var req jobRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	op := s.newOperation("deploy")
+	job, err := s.render(&req, logging.NewLoggerWithHook(req.Key+req.Source, op.log))
+	if err != nil {
+		s.finish(op, false, err)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.startDeployment(w, op, job.Deployment)
+}
+
+// redeployRequest is the body of a redeploy request
+type redeployRequest struct {
+	Job    string                 `json:"job"`
+	Groups []string               `json:"groups"`
+	Deploy map[string]interface{} `json:"deploy"`
+}
+
+func (s *apiServer) handleRedeploy(w http.ResponseWriter, r *http.Request) {
+	var req redeployRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if req.Job == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("\"job\" is required"))
+		return
+	}
+
+	i := &deploy.RedeploymentInput{
+		Client:         s.client,
+		JobName:        req.Job,
+		TaskGroupNames: req.Groups,
+		Detach:         true,
+	}
+	for k, v := range req.Deploy {
+		var err error
+		switch k {
+		case "auto_promote":
+			i.AutoPromote, err = boolSetting(k, v)
+		case "auto_revert":
+			i.AutoRevert, err = boolSetting(k, v)
+		case "canary_analysis":
+			var analyze bool
+			if analyze, err = boolSetting(k, v); analyze {
+				i.CanaryAnalysis = s.analysis
+			}
+		case "canary_soak":
+			if i.CanarySoak, err = time.ParseDuration(fmt.Sprint(v)); err == nil {
+				i.ConsulClient = s.consul
+			}
+		case "on_failure":
+			i.OnFailure, err = deploy.ParseFailureAction(fmt.Sprint(v))
+		case "promote_order":
+			i.PromoteOrder, err = promoteOrderSetting(k, v)
+		case "promote_pause":
+			i.PromotePause, err = time.ParseDuration(fmt.Sprint(v))
+		case "timeout":
+			i.Timeout, err = time.ParseDuration(fmt.Sprint(v))
+		default:
+			err = fmt.Errorf("unsupported deploy setting \"%s\"", k)
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	op := s.newOperation("redeploy")
+	i.Logger = logging.NewLoggerWithHook(req.Job, op.log)
+	d, err := deploy.NewRedeployment(i)
+	if err != nil {
+		s.finish(op, false, err)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.startDeployment(w, op, d)
+}
+
+// scaleRequest is the body of a scale request
+type scaleRequest struct {
+	Job   string `json:"job"`
+	Group string `json:"group"`
+	Count *int   `json:"count"`
+	Delta *int   `json:"delta"`
+}
+
+func (s *apiServer) handleScale(w http.ResponseWriter, r *http.Request) {
+	var req scaleRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if req.Job == "" || req.Group == "" || (req.Count == nil) == (req.Delta == nil) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("\"job\", \"group\" and exactly one of \"count\" or \"delta\" are required"))
+		return
+	}
+
+	o := &ops.ScaleOptions{Job: req.Job, Group: req.Group}
+	if req.Delta != nil {
+		o.Count, o.Relative = *req.Delta, true
+	} else {
+		o.Count = *req.Count
+	}
+
+	result, err := ops.Scale(r.Context(), s.client, o)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"job":            req.Job,
+		"group":          req.Group,
+		"previous_count": result.PreviousCount,
+		"count":          result.Count,
+	})
+}
+
+// restartRequest is the body of a restart request
+type restartRequest struct {
+	Job   string `json:"job"`
+	Group string `json:"group"`
+}
+
+func (s *apiServer) handleRestart(w http.ResponseWriter, r *http.Request) {
+	var req restartRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if req.Job == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("\"job\" is required"))
+		return
+	}
+
+	if err := ops.Restart(r.Context(), s.client, &ops.RestartOptions{Job: req.Job, Group: req.Group}); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"job": req.Job, "group": req.Group})
+}
+
+// handleOperation serves the status or the events of an operation
+func (s *apiServer) handleOperation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/v1/operations/")
+	events := strings.HasSuffix(id, "/events")
+	id = strings.TrimSuffix(id, "/events")
+
+	s.mu.Lock()
+	op, ok := s.operations[id]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("operation \"%s\" not found", id))
+		return
+	}
+
+	if !events {
+		writeJSON(w, http.StatusOK, op.status())
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming not supported"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	n := 0
+	for {
+		events, notify, status := op.since(n)
+		n += len(events)
+		for _, e := range events {
+			writeEvent(w, "log", e)
+		}
+		if status.Status != operationRunning {
+			writeEvent(w, "done", status)
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-notify:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// startDeployment registers the deployment's job, which must be detached,
+// responds with the operation, then monitors the deployment in the background
+func (s *apiServer) startDeployment(w http.ResponseWriter, op *operation, d *deploy.Deployment) {
+	jobID := d.JobID()
+
+	s.mu.Lock()
+	if id, ok := s.running[jobID]; ok {
+		s.mu.Unlock()
+		err := fmt.Errorf("job \"%s\" is already being deployed by operation \"%s\"", jobID, id)
+		s.finish(op, false, err)
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	s.running[jobID] = op.id
+	s.mu.Unlock()
+
+	op.update(func(st *operationStatus) { st.JobID = jobID })
+
+	if _, err := d.Deploy(s.ctx); err != nil {
+		s.finish(op, false, err)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	op.update(func(st *operationStatus) {
+		st.EvalID = d.EvalID()
+		st.DeploymentID = d.DeploymentID()
+	})
+
+	writeJSON(w, http.StatusAccepted, op.status())
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if d.DeploymentID() == "" {
+			s.finish(op, true, nil)
+			return
+		}
+		success, err := d.Watch(s.ctx)
+		op.update(func(st *operationStatus) { st.CanaryAnalysis = d.CanaryAnalysis() })
+		s.finish(op, success, err)
+	}()
+}
+
+// operationStatus is the status of an asynchronous operation
+type operationStatus struct {
+	ID             string                 `json:"id"`
+	Type           string                 `json:"type"`
+	JobID          string                 `json:"job_id,omitempty"`
+	EvalID         string                 `json:"eval_id,omitempty"`
+	DeploymentID   string                 `json:"deployment_id,omitempty"`
+	CanaryAnalysis *deploy.CanaryAnalysis `json:"canary_analysis,omitempty"`
+	Status         string                 `json:"status"`
+	Error          string                 `json:"error,omitempty"`
+	Started        time.Time              `json:"started"`
+	Finished       *time.Time             `json:"finished,omitempty"`
+}
+
+// operation statuses
+const (
+	operationRunning = "running"
+	operationSuccess = "success"
+	operationFailed  = "failed"
+)
+
+// operationEvent is a log message of an operation
+type operationEvent struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
+}
+
+// operation is an asynchronous deployment and its log messages
+type operation struct {
+	id     string
+	mu     sync.Mutex
+	st     operationStatus
+	events []*operationEvent
+	notify chan struct{} // closed when an event is added or the operation finishes
+}
+
+// newOperation starts tracking a new operation, forgetting
+// operations that finished more than operationRetention ago
+func (s *apiServer) newOperation(opType string) *operation {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	op := &operation{
+		id:     hex.EncodeToString(b),
+		notify: make(chan struct{}),
+	}
+	op.st = operationStatus{ID: op.id, Type: opType, Status: operationRunning, Started: time.Now().UTC()}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, o := range s.operations {
+		if st := o.status(); st.Finished != nil && time.Since(*st.Finished) > operationRetention {
+			delete(s.operations, id)
+		}
+	}
+	s.operations[op.id] = op
+	return op
+}
+
+// finish marks an operation as finished and releases its job
+func (s *apiServer) finish(op *operation, success bool, err error) {
+	if err == nil && !success {
+		err = fmt.Errorf("deployment unsuccessful")
+	}
+
+	s.mu.Lock()
+	if jobID := op.status().JobID; s.running[jobID] == op.id {
+		delete(s.running, jobID)
+	}
+	s.mu.Unlock()
+
+	op.update(func(st *operationStatus) {
+		now := time.Now().UTC()
+		st.Finished = &now
+		st.Status = operationSuccess
+		if err != nil {
+			st.Status = operationFailed
+			st.Error = err.Error()
+		}
+	})
+}
+
+// log adds a log message to the operation, used as a logger hook
+func (op *operation) log(level, message string) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.events = append(op.events, &operationEvent{Time: time.Now().UTC(), Level: level, Message: message})
+	close(op.notify)
+	op.notify = make(chan struct{})
+}
+
+// update changes the operation's status
+func (op *operation) update(fn func(*operationStatus)) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	fn(&op.st)
+	close(op.notify)
+	op.notify = make(chan struct{})
+}
+
+// status returns a copy of the operation's status
+func (op *operation) status() operationStatus {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	return op.st
+}
+
+// since returns the events after the first n, a channel closed once there
+// are more events or the status changes, and the operation's status
+func (op *operation) since(n int) ([]*operationEvent, <-chan struct{}, operationStatus) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	return op.events[n:], op.notify, op.st
+}
+
+// decodeRequest decodes a JSON request body, responding with an error
+// and returning false if it cannot be decoded
+func decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+		return false
+	}
+	return true
+}
+
+// writeJSON responds with a value encoded as JSON
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError responds with an error
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// writeEvent writes a Server-Sent Event with a JSON encoded value
+func writeEvent(w io.Writer, event string, v interface{}) {
+	b, _ := json.Marshal(v)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+}
+
+// boolSetting returns a setting that must be a bool, or a string parsing as one
+func boolSetting(key string, v interface{}) (bool, error) {
+	switch b := v.(type) {
+	case bool:
+		return b, nil
+	case string:
+		switch b {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	}
+	return false, fmt.Errorf("deploy setting \"%s\" must be true or false", key)
+}
+
+// promoteOrderSetting returns a promote order setting, which must be a
+// list of strings or a comma-separated string
+func promoteOrderSetting(key string, v interface{}) ([][]string, error) {
+	switch order := v.(type) {
+	case string:
+		return deploy.ParsePromoteOrder([]string{order})
+	case []interface{}:
+		entries := make([]string, 0, len(order))
+		for _, e := range order {
+			s, ok := e.(string)
+			if !ok {
+				return nil, fmt.Errorf("deploy setting \"%s\" must be a list of strings", key)
+			}
+			entries = append(entries, s)
+		}
+		return deploy.ParsePromoteOrder(entries)
+	}
+	return nil, fmt.Errorf("deploy setting \"%s\" must be a list of strings", key)
+}
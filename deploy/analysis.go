@@ -0,0 +1,298 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/hashicorp/nomad/nomad/structs"
+	"github.com/pkg/errors"
+)
+
+// analysisEvents are the task event types, other than restarts, that
+// canary analysis counts as failures
+var analysisEvents = []string{
+	api.TaskNotRestarting,
+	api.TaskDriverFailure,
+	api.TaskSetupFailure,
+	api.TaskFailedValidation,
+	api.TaskArtifactDownloadFailed,
+}
+
+// AnalysisThresholds are how much worse canaries may be than the stable
+// allocations of the same task group before they fail analysis. A
+// negative threshold is not checked.
+type AnalysisThresholds struct {
+	MaxRestarts       float64 // restarts per canary allowed over the stable allocations' average
+	MaxFailureEvents  float64 // failure events per canary allowed over the stable allocations' average
+	MaxCPUIncrease    float64 // percent more CPU than the stable allocations' average allowed
+	MaxMemoryIncrease float64 // percent more memory than the stable allocations' average allowed
+}
+
+// CanaryAnalysis is the comparison of a deployment's canaries against the
+// stable allocations of the same task groups before they were promoted
+type CanaryAnalysis struct {
+	Passed bool             `json:"passed"`
+	Groups []*GroupAnalysis `json:"groups"`
+}
+
+// GroupAnalysis is the comparison of a task group's canaries against its
+// stable allocations
+type GroupAnalysis struct {
+	Group    string        `json:"group"`
+	Canary   *AllocSignals `json:"canary"`
+	Stable   *AllocSignals `json:"stable,omitempty"` // nil if the group has no stable allocations
+	Failures []string      `json:"failures,omitempty"`
+}
+
+// AllocSignals are the averages per allocation of a set of allocations,
+// counted since the canaries were placed
+type AllocSignals struct {
+	Allocations int                `json:"allocations"`
+	Restarts    float64            `json:"restarts"`
+	Events      map[string]float64 `json:"events,omitempty"`     // failure events by type
+	LastEvent   string             `json:"last_event,omitempty"` // the latest failure event
+	CPU         *float64           `json:"cpu_mhz,omitempty"`    // nil if stats are unavailable
+	MemoryMB    *float64           `json:"memory_mb,omitempty"`  // nil if stats are unavailable
+	events      int                // the total failure events, for comparison
+	lastTime    int64              // when the latest failure event occurred
+}
+
+// CanaryAnalysis returns the analysis of the deployment's canaries, if any
+func (d *Deployment) CanaryAnalysis() *CanaryAnalysis {
+	return d.analysisReport
+}
+
+// promoteCanaries promotes the given task groups, or every group if none
+// are given, once their canaries are healthy, and returns whether they
+// were promoted. If canaries are analyzed, the deployment is failed rather
+// than promoted if they fail analysis. If approval is required, promotion
+// waits for it, and the deployment is failed if it is rejected.
+func (d *Deployment) promoteCanaries(ctx context.Context, dep *api.Deployment, groups []string) (bool, error) {
+	if d.analysis != nil {
+		reason, err := d.analyzeCanaries(dep, groups)
+		if err != nil {
+			return false, err
+		}
+		if reason != "" {
+			return false, d.abortPromotion(dep, "canary analysis failed: "+reason)
+		}
+	}
+
+	// the deployment is approved once, rather than every group promoted in order
+	if d.approvalKey != "" && !d.approved {
+		approved, reason, err := d.awaitApproval(ctx, dep)
+		switch {
+		case err != nil:
+			return false, err
+		case reason != "":
+			return false, d.abortPromotion(dep, reason)
+		case !approved:
+			return false, nil
+		}
+		d.approved = true
+	}
+
+	return true, d.promote(groups)
+}
+
+// analyzeCanaries compares the canaries of the given task groups, or of
+// every group if none are given, against the stable allocations of the
+// same groups, logs the report, and returns why the canaries failed
+// analysis, if they did
+func (d *Deployment) analyzeCanaries(dep *api.Deployment, groups []string) (string, error) {
+	allocs, _, err := d.client.Jobs().Allocations(*d.job.ID, false, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get job allocations")
+	}
+
+	canaries := make(map[string]bool)
+	for _, id := range canaryAllocIDs(dep, groups) {
+		canaries[id] = true
+	}
+
+	// signals are counted over the same period for every allocation
+	var since int64
+	byGroup := make(map[string][]*api.AllocationListStub)
+	stable := make(map[string][]*api.AllocationListStub)
+	for _, alloc := range allocs {
+		switch {
+		case canaries[alloc.ID]:
+			byGroup[alloc.TaskGroup] = append(byGroup[alloc.TaskGroup], alloc)
+			if since == 0 || alloc.CreateTime < since {
+				since = alloc.CreateTime
+			}
+		case alloc.JobVersion != dep.JobVersion && alloc.ClientStatus == structs.AllocClientStatusRunning:
+			stable[alloc.TaskGroup] = append(stable[alloc.TaskGroup], alloc)
+		}
+	}
+
+	var names []string
+	for name := range byGroup {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if d.analysisReport == nil {
+		d.analysisReport = &CanaryAnalysis{Passed: true}
+	}
+
+	var failures []string
+	var results []*GroupAnalysis
+	for _, name := range names {
+		g := &GroupAnalysis{Group: name, Canary: d.allocSignals(byGroup[name], since)}
+		if len(stable[name]) > 0 {
+			g.Stable = d.allocSignals(stable[name], since)
+			g.Failures = d.analysis.compare(g.Canary, g.Stable)
+		}
+		for _, f := range g.Failures {
+			failures = append(failures, fmt.Sprintf("group \"%s\" %s", name, f))
+		}
+		results = append(results, g)
+	}
+
+	d.analysisReport.Groups = append(d.analysisReport.Groups, results...)
+	d.analysisReport.Passed = d.analysisReport.Passed && len(failures) == 0
+	d.logCanaryAnalysis(results)
+
+	return strings.Join(failures, ", "), nil
+}
+
+// allocSignals returns the average signals of a set of allocations since
+// the given time, in Unix nanoseconds
+func (d *Deployment) allocSignals(allocs []*api.AllocationListStub, since int64) *AllocSignals {
+	s := &AllocSignals{Allocations: len(allocs), Events: make(map[string]float64)}
+
+	var restarts int
+	var cpu, memory float64
+	var sampled int
+	for _, alloc := range allocs {
+		for _, state := range alloc.TaskStates {
+			for _, event := range state.Events {
+				if event.Time < since {
+					continue
+				}
+				switch {
+				case event.Type == api.TaskRestarting:
+					restarts++
+				case containsString(analysisEvents, event.Type):
+					s.Events[event.Type]++
+					s.events++
+					if event.Time > s.lastTime {
+						s.lastTime = event.Time
+						s.LastEvent = fmt.Sprintf("%s: %s", event.Type, buildTaskEventMessage(event))
+					}
+				}
+			}
+		}
+
+		usage, err := d.client.Allocations().Stats(&api.Allocation{ID: alloc.ID, NodeID: alloc.NodeID}, nil)
+		if err != nil || usage.ResourceUsage == nil {
+			d.log.Debug("no resource usage for allocation \"%s\": %v", limit(alloc.ID, d.idLen), err)
+			continue
+		}
+		if cs := usage.ResourceUsage.CpuStats; cs != nil {
+			cpu += cs.TotalTicks
+		}
+		if ms := usage.ResourceUsage.MemoryStats; ms != nil {
+			memory += float64(ms.RSS) / 1024 / 1024
+		}
+		sampled++
+	}
+
+	n := float64(len(allocs))
+	s.Restarts = float64(restarts) / n
+	for t, count := range s.Events {
+		s.Events[t] = count / n
+	}
+	if sampled > 0 {
+		cpu /= float64(sampled)
+		memory /= float64(sampled)
+		s.CPU, s.MemoryMB = &cpu, &memory
+	}
+	return s
+}
+
+// compare returns the thresholds that canaries exceed compared to stable allocations
+func (t *AnalysisThresholds) compare(canary, stable *AllocSignals) []string {
+	var failures []string
+
+	if t.MaxRestarts >= 0 && canary.Restarts-stable.Restarts > t.MaxRestarts {
+		failures = append(failures, fmt.Sprintf("canaries restarted %.1f times on average, stable allocations %.1f", canary.Restarts, stable.Restarts))
+	}
+
+	canaryEvents := float64(canary.events) / float64(canary.Allocations)
+	stableEvents := float64(stable.events) / float64(stable.Allocations)
+	if t.MaxFailureEvents >= 0 && canaryEvents-stableEvents > t.MaxFailureEvents {
+		failures = append(failures, fmt.Sprintf("canaries had %.1f failure events on average, stable allocations %.1f", canaryEvents, stableEvents))
+	}
+
+	if increase, ok := percentIncrease(canary.CPU, stable.CPU); ok && t.MaxCPUIncrease >= 0 && increase > t.MaxCPUIncrease {
+		failures = append(failures, fmt.Sprintf("canaries used %.0f%% more CPU than stable allocations", increase))
+	}
+	if increase, ok := percentIncrease(canary.MemoryMB, stable.MemoryMB); ok && t.MaxMemoryIncrease >= 0 && increase > t.MaxMemoryIncrease {
+		failures = append(failures, fmt.Sprintf("canaries used %.0f%% more memory than stable allocations", increase))
+	}
+
+	return failures
+}
+
+// percentIncrease returns how many percent larger a value is than a base
+// value, if both are known and the base is positive
+func percentIncrease(value, base *float64) (float64, bool) {
+	if value == nil || base == nil || *base <= 0 {
+		return 0, false
+	}
+	return (*value - *base) / *base * 100, true
+}
+
+// logCanaryAnalysis logs a report of the analysis of task groups' canaries
+func (d *Deployment) logCanaryAnalysis(groups []*GroupAnalysis) {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Group\tAllocations\tRestarts\tFailure Events\tCPU (MHz)\tMemory (MB)\tResult")
+	for _, g := range groups {
+		result := "passed"
+		switch {
+		case g.Stable == nil:
+			result = "no stable allocations"
+		case len(g.Failures) > 0:
+			result = "failed"
+		}
+		fmt.Fprintf(w, "%s (canary)\t%s\t%s\n", g.Group, formatSignals(g.Canary), result)
+		if g.Stable != nil {
+			fmt.Fprintf(w, "%s (stable)\t%s\t\n", g.Group, formatSignals(g.Stable))
+		}
+	}
+	w.Flush()
+
+	d.log.Info("canary analysis of deployment \"%s\":", limit(d.deploymentID, d.idLen))
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		d.log.Info("  %s", line)
+	}
+	for _, g := range groups {
+		if g.Canary.LastEvent != "" {
+			d.log.Info("  group \"%s\" latest canary failure event: %s", g.Group, g.Canary.LastEvent)
+		}
+		for _, f := range g.Failures {
+			d.log.Error("  group \"%s\" %s", g.Group, f)
+		}
+	}
+}
+
+// formatSignals returns the columns of the analysis report for a set of allocations
+func formatSignals(s *AllocSignals) string {
+	cpu, memory := "-", "-"
+	if s.CPU != nil {
+		cpu = fmt.Sprintf("%.0f", *s.CPU)
+	}
+	if s.MemoryMB != nil {
+		memory = fmt.Sprintf("%.0f", *s.MemoryMB)
+	}
+	return fmt.Sprintf("%d\t%.1f\t%.1f\t%s\t%s",
+		s.Allocations, s.Restarts, float64(s.events)/float64(s.Allocations), cpu, memory)
+}
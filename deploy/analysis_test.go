@@ -0,0 +1,71 @@
+package deploy
+
+import "testing"
+
+func TestAnalysisThresholdsCompare(t *testing.T) {
+	stable := &AllocSignals{Allocations: 4, Restarts: 0.5, events: 2, CPU: floatToPtr(100), MemoryMB: floatToPtr(200)}
+
+	cases := []struct {
+		name       string
+		thresholds *AnalysisThresholds
+		canary     *AllocSignals
+		failures   int
+	}{
+		{
+			name:       "within thresholds",
+			thresholds: &AnalysisThresholds{MaxRestarts: 1, MaxFailureEvents: 1, MaxCPUIncrease: 50, MaxMemoryIncrease: 50},
+			canary:     &AllocSignals{Allocations: 2, Restarts: 1.5, events: 2, CPU: floatToPtr(150), MemoryMB: floatToPtr(300)},
+			failures:   0,
+		},
+		{
+			name:       "every threshold exceeded",
+			thresholds: &AnalysisThresholds{MaxRestarts: 1, MaxFailureEvents: 1, MaxCPUIncrease: 50, MaxMemoryIncrease: 50},
+			canary:     &AllocSignals{Allocations: 2, Restarts: 2, events: 4, CPU: floatToPtr(151), MemoryMB: floatToPtr(301)},
+			failures:   4,
+		},
+		{
+			name:       "negative thresholds not checked",
+			thresholds: &AnalysisThresholds{MaxRestarts: -1, MaxFailureEvents: -1, MaxCPUIncrease: -1, MaxMemoryIncrease: -1},
+			canary:     &AllocSignals{Allocations: 2, Restarts: 10, events: 20, CPU: floatToPtr(1000), MemoryMB: floatToPtr(2000)},
+			failures:   0,
+		},
+		{
+			name:       "zero thresholds",
+			thresholds: &AnalysisThresholds{},
+			canary:     &AllocSignals{Allocations: 2, Restarts: 1, events: 1, CPU: floatToPtr(101), MemoryMB: floatToPtr(200)},
+			failures:   2,
+		},
+		{
+			name:       "unknown usage not compared",
+			thresholds: &AnalysisThresholds{MaxCPUIncrease: 0, MaxMemoryIncrease: 0, MaxRestarts: -1, MaxFailureEvents: -1},
+			canary:     &AllocSignals{Allocations: 2},
+			failures:   0,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			failures := c.thresholds.compare(c.canary, stable)
+			if len(failures) != c.failures {
+				t.Errorf("got failures %q, want %d", failures, c.failures)
+			}
+		})
+	}
+}
+
+func TestPercentIncrease(t *testing.T) {
+	if increase, ok := percentIncrease(floatToPtr(150), floatToPtr(100)); !ok || increase != 50 {
+		t.Errorf("got %v, %v, want 50, true", increase, ok)
+	}
+	if _, ok := percentIncrease(floatToPtr(150), floatToPtr(0)); ok {
+		t.Error("expected no increase over a zero base")
+	}
+	if _, ok := percentIncrease(nil, floatToPtr(100)); ok {
+		t.Error("expected no increase of an unknown value")
+	}
+}
+
+// floatToPtr returns the pointer to a float64
+func floatToPtr(f float64) *float64 {
+	return &f
+}
@@ -0,0 +1,211 @@
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/nomad/api"
+	"github.com/hashicorp/nomad/nomad/structs"
+	"github.com/pkg/errors"
+)
+
+// ApprovalsKey is the path under a Consul job key where the approvals of
+// the job's deployments are recorded, by deployment ID
+const ApprovalsKey = "deploy/approvals"
+
+// The statuses of an approval
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+)
+
+// Approval is the record of a deployment whose canaries await manual
+// approval before they are promoted
+type Approval struct {
+	DeploymentID string     `json:"deployment_id"`
+	JobID        string     `json:"job_id"`
+	JobVersion   uint64     `json:"job_version"`
+	Status       string     `json:"status"`
+	Requested    time.Time  `json:"requested"`
+	DecidedBy    string     `json:"decided_by,omitempty"` // who approved or rejected the deployment
+	Decided      *time.Time `json:"decided,omitempty"`    // when the deployment was approved or rejected
+	Message      string     `json:"message,omitempty"`    // the reason given for the decision, if any
+}
+
+// DecideApprovalInput represents the input for approving or rejecting a deployment
+type DecideApprovalInput struct {
+	Client       *consul.Client // the Consul API client, one is created from the environment if nil
+	NomadClient  *api.Client    // checks the deployment is still running, if set
+	JobKey       string         // the full Consul job key the deployment awaits approval under
+	DeploymentID string         // the ID (or unique ID prefix) of the deployment, required if more than one is pending
+	Approve      bool           // whether to approve the deployment, rather than reject it
+	By           string         // who is approving or rejecting the deployment
+	Message      string         // the reason for the decision, if any
+}
+
+// awaitApproval records that the deployment's healthy canaries await
+// approval, unless already recorded, and waits for them to be approved or
+// rejected. It returns whether they were approved, or why not if they were
+// rejected. Neither is returned if the wait ends otherwise, such as when
+// interrupted or timed out, or if the deployment is no longer running.
+func (d *Deployment) awaitApproval(ctx context.Context, dep *api.Deployment) (bool, string, error) {
+	kv := d.consul.KV()
+	key := path.Join(d.approvalKey, dep.ID)
+
+	pair, meta, err := kv.Get(key, nil)
+	if err != nil {
+		return false, "", errors.Wrap(err, "failed to read approval")
+	}
+	if pair == nil {
+		b, err := json.Marshal(&Approval{
+			DeploymentID: dep.ID,
+			JobID:        dep.JobID,
+			JobVersion:   dep.JobVersion,
+			Status:       ApprovalPending,
+			Requested:    time.Now().UTC(),
+		})
+		if err != nil {
+			return false, "", err
+		}
+		if _, err := kv.Put(&consul.KVPair{Key: key, Value: b}, nil); err != nil {
+			return false, "", errors.Wrap(err, "failed to request approval")
+		}
+	}
+	d.log.Info("deployment \"%s\" has healthy canaries and awaits approval (see \"nomadctl approve\" and \"nomadctl reject\")", limit(dep.ID, d.idLen))
+
+	q := &consul.QueryOptions{}
+	for {
+		if pair != nil {
+			var a Approval
+			if err := json.Unmarshal(pair.Value, &a); err != nil {
+				return false, "", errors.Wrapf(err, "failed to read approval \"%s\"", key)
+			}
+			switch a.Status {
+			case ApprovalApproved:
+				d.log.Info("deployment \"%s\" approved by %s at %s", limit(dep.ID, d.idLen), a.DecidedBy, formatDecided(a.Decided))
+				return true, "", nil
+			case ApprovalRejected:
+				reason := fmt.Sprintf("rejected by %s at %s", a.DecidedBy, formatDecided(a.Decided))
+				if a.Message != "" {
+					reason += ": " + a.Message
+				}
+				return false, reason, nil
+			}
+		} else if q.WaitIndex > 0 {
+			return false, fmt.Sprintf("approval \"%s\" was deleted", key), nil
+		}
+
+		if ctx.Err() != nil || d.timedOut() {
+			return false, "", nil
+		}
+
+		// stop waiting if the deployment is failed or cancelled meanwhile
+		current, _, err := d.client.Deployments().Info(dep.ID, nil)
+		if err != nil {
+			return false, "", errors.Wrap(err, "failed to get deployment")
+		}
+		if current.Status != structs.DeploymentStatusRunning {
+			return false, "", nil
+		}
+
+		q.WaitIndex = meta.LastIndex
+		q.WaitTime = d.waitTime(ctx, 10*time.Second)
+		if pair, meta, err = kv.Get(key, q); err != nil {
+			return false, "", errors.Wrap(err, "failed to read approval")
+		}
+	}
+}
+
+// DecideApproval approves or rejects a deployment awaiting approval under
+// a job key, and returns the updated approval
+func DecideApproval(i *DecideApprovalInput) (*Approval, error) {
+	client := i.Client
+	if client == nil {
+		var err error
+		if client, err = consul.NewClient(consul.DefaultConfig()); err != nil {
+			return nil, err
+		}
+	}
+
+	prefix := path.Join(i.JobKey, ApprovalsKey) + "/"
+	pairs, _, err := client.KV().List(prefix, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list approvals")
+	}
+
+	// find the pending approval of the deployment
+	var pending []*consul.KVPair
+	var approvals []*Approval
+	for _, pair := range pairs {
+		var a Approval
+		if err := json.Unmarshal(pair.Value, &a); err != nil {
+			return nil, errors.Wrapf(err, "failed to read approval \"%s\"", pair.Key)
+		}
+		if a.Status == ApprovalPending && strings.HasPrefix(a.DeploymentID, i.DeploymentID) {
+			pending = append(pending, pair)
+			approvals = append(approvals, &a)
+		}
+	}
+
+	switch {
+	case len(pending) == 0 && i.DeploymentID != "":
+		return nil, fmt.Errorf("no deployment \"%s\" awaiting approval under \"%s\"", i.DeploymentID, i.JobKey)
+	case len(pending) == 0:
+		return nil, fmt.Errorf("no deployment awaiting approval under \"%s\"", i.JobKey)
+	case len(pending) > 1:
+		var ids []string
+		for _, a := range approvals {
+			ids = append(ids, a.DeploymentID)
+		}
+		sort.Strings(ids)
+		return nil, fmt.Errorf("more than one deployment awaiting approval under \"%s\", specify one of: %s", i.JobKey, strings.Join(ids, ", "))
+	}
+	pair, a := pending[0], approvals[0]
+
+	if i.NomadClient != nil {
+		dep, _, err := i.NomadClient.Deployments().Info(a.DeploymentID, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get deployment")
+		}
+		if dep.Status != structs.DeploymentStatusRunning {
+			return nil, fmt.Errorf("deployment \"%s\" is no longer running, it has status \"%s\"", a.DeploymentID, dep.Status)
+		}
+	}
+
+	now := time.Now().UTC()
+	a.Status = ApprovalRejected
+	if i.Approve {
+		a.Status = ApprovalApproved
+	}
+	a.DecidedBy = i.By
+	a.Decided = &now
+	a.Message = i.Message
+
+	if pair.Value, err = json.Marshal(a); err != nil {
+		return nil, err
+	}
+	// only update the approval if it has not changed since it was read
+	ok, _, err := client.KV().CAS(pair, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to update approval")
+	}
+	if !ok {
+		return nil, fmt.Errorf("approval of deployment \"%s\" changed while being updated, try again", a.DeploymentID)
+	}
+	return a, nil
+}
+
+// formatDecided returns when an approval was decided, for log messages
+func formatDecided(t *time.Time) string {
+	if t == nil {
+		return "an unknown time"
+	}
+	return t.Format(time.RFC3339)
+}
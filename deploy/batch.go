@@ -0,0 +1,265 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bdclark/nomadctl/logging"
+)
+
+// BatchJob is a deployment that is part of a batch, and is only deployed
+// once the jobs it depends on have been deployed successfully
+type BatchJob struct {
+	Name       string      // unique name of the job within the batch
+	DependsOn  []string    // names of the jobs that must be deployed first
+	Deployment *Deployment // the deployment to run
+	Plan       *PlanInput  // if set, the job is planned with this input instead of deployed
+}
+
+// BatchResult is the result of deploying a job within a batch
+type BatchResult struct {
+	Name         string
+	DeploymentID string
+	Success      bool
+	Skipped      bool // the job was not deployed
+	Planned      bool // the job was planned rather than deployed
+	Changes      bool // the plan found allocation changes
+	Err          error
+	Duration     time.Duration
+}
+
+// BatchRunInput represents the input for running a batch
+type BatchRunInput struct {
+	Parallel    int  // how many jobs to deploy at once, at least 1
+	StopOnError bool // whether to stop starting jobs once any job fails
+}
+
+// Batch is a set of deployments run in dependency order
+type Batch struct {
+	jobs       []*BatchJob
+	byName     map[string]*BatchJob
+	dependents map[string][]string
+}
+
+// NewBatch validates the dependencies of a set of jobs and returns a batch
+func NewBatch(jobs []*BatchJob) (*Batch, error) {
+	b := &Batch{
+		jobs:       jobs,
+		byName:     make(map[string]*BatchJob),
+		dependents: make(map[string][]string),
+	}
+
+	for _, j := range jobs {
+		if _, ok := b.byName[j.Name]; ok {
+			return nil, fmt.Errorf("job \"%s\" specified more than once", j.Name)
+		}
+		b.byName[j.Name] = j
+	}
+
+	for _, j := range jobs {
+		for _, dep := range j.DependsOn {
+			if _, ok := b.byName[dep]; !ok {
+				return nil, fmt.Errorf("job \"%s\" depends on unknown job \"%s\"", j.Name, dep)
+			}
+			b.dependents[dep] = append(b.dependents[dep], j.Name)
+		}
+	}
+
+	if _, err := b.Order(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Order returns the job names in an order that satisfies their
+// dependencies, or an error if the dependencies contain a cycle
+func (b *Batch) Order() ([]string, error) {
+	remaining := make(map[string]int)
+	var ready []string
+	for _, j := range b.jobs {
+		remaining[j.Name] = len(j.DependsOn)
+		if len(j.DependsOn) == 0 {
+			ready = append(ready, j.Name)
+		}
+	}
+
+	var order []string
+	for len(ready) > 0 {
+		name := ready[0]
+		ready = ready[1:]
+		order = append(order, name)
+
+		for _, dependent := range b.dependents[name] {
+			remaining[dependent]--
+			if remaining[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	if len(order) != len(b.jobs) {
+		var cyclic []string
+		for name, n := range remaining {
+			if n > 0 {
+				cyclic = append(cyclic, name)
+			}
+		}
+		sort.Strings(cyclic)
+		return nil, fmt.Errorf("dependency cycle between jobs: %s", strings.Join(cyclic, ", "))
+	}
+	return order, nil
+}
+
+// Run deploys the jobs in dependency order, deploying up to i.Parallel jobs
+// at once. When a job fails, the jobs that depend on it (directly or not)
+// are skipped. Results are returned in the order the jobs were given.
+func (b *Batch) Run(ctx context.Context, i *BatchRunInput) []*BatchResult {
+	parallel := i.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	results := make(map[string]*BatchResult)
+	remaining := make(map[string]int)
+	var ready []string
+	for _, j := range b.jobs {
+		remaining[j.Name] = len(j.DependsOn)
+		if len(j.DependsOn) == 0 {
+			ready = append(ready, j.Name)
+		}
+	}
+
+	done := make(chan *BatchResult)
+	running := 0
+	halted := false
+
+	for {
+		for len(ready) > 0 && running < parallel && !halted && ctx.Err() == nil {
+			job := b.byName[ready[0]]
+			ready = ready[1:]
+			running++
+			go func() { done <- runBatchJob(ctx, job) }()
+		}
+
+		if running == 0 {
+			break
+		}
+
+		r := <-done
+		running--
+		results[r.Name] = r
+
+		if !r.Success {
+			if i.StopOnError && !halted {
+				logging.Error("job \"%s\" failed, not starting any more jobs", r.Name)
+				halted = true
+			}
+			b.skipDependents(r.Name, results)
+			continue
+		}
+
+		for _, dependent := range b.dependents[r.Name] {
+			remaining[dependent]--
+			if remaining[dependent] == 0 && results[dependent] == nil {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	out := make([]*BatchResult, 0, len(b.jobs))
+	for _, j := range b.jobs {
+		r, ok := results[j.Name]
+		if !ok {
+			r = &BatchResult{Name: j.Name, Skipped: true, Err: fmt.Errorf("not started")}
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// skipDependents marks every job that depends on the failed job as skipped
+func (b *Batch) skipDependents(failed string, results map[string]*BatchResult) {
+	for _, dependent := range b.dependents[failed] {
+		if results[dependent] != nil {
+			continue
+		}
+		logging.Warning("skipping job \"%s\" because job \"%s\" failed", dependent, failed)
+		results[dependent] = &BatchResult{
+			Name:    dependent,
+			Skipped: true,
+			Err:     fmt.Errorf("dependency \"%s\" failed", failed),
+		}
+		b.skipDependents(dependent, results)
+	}
+}
+
+// runBatchJob deploys (or plans) a single job of a batch
+func runBatchJob(ctx context.Context, job *BatchJob) *BatchResult {
+	start := time.Now()
+	r := &BatchResult{Name: job.Name}
+
+	var err error
+	if job.Plan != nil {
+		r.Planned = true
+		r.Changes, err = job.Deployment.Plan(job.Plan)
+	} else {
+		var success bool
+		success, err = job.Deployment.Deploy(ctx)
+		if err == nil && !success {
+			err = fmt.Errorf("deployment unsuccessful")
+		}
+	}
+	if err != nil {
+		logging.Error("job \"%s\" failed: %v", job.Name, err)
+	}
+
+	r.DeploymentID = job.Deployment.DeploymentID()
+	r.Success = err == nil
+	r.Err = err
+	r.Duration = time.Since(start)
+	return r
+}
+
+// Result returns the outcome of the job as shown in the batch summary
+func (r *BatchResult) Result() string {
+	switch {
+	case r.Skipped:
+		return "skipped"
+	case !r.Success:
+		return "failed"
+	case r.Planned && r.Changes:
+		return "changes"
+	case r.Planned:
+		return "no changes"
+	}
+	return "success"
+}
+
+// PrintBatchResults prints a summary table of the results of a batch
+func PrintBatchResults(results []*BatchResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Job\tResult\tDeployment ID\tDuration")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Name, r.Result(), limit(r.DeploymentID, 8), r.Duration.Round(time.Second))
+	}
+	w.Flush()
+}
+
+// PrintBatchResultsMarkdown prints a summary table of the results of a
+// batch as markdown, including the error of each failed job
+func PrintBatchResultsMarkdown(results []*BatchResult) {
+	fmt.Println("| Job | Result | Error |")
+	fmt.Println("|-----|--------|-------|")
+	for _, r := range results {
+		var msg string
+		if r.Err != nil {
+			msg = strings.Replace(r.Err.Error(), "|", "\\|", -1)
+		}
+		fmt.Printf("| `%s` | %s | %s |\n", r.Name, r.Result(), msg)
+	}
+}
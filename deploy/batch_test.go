@@ -0,0 +1,221 @@
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+// planStub is an httptest Nomad agent planning any job, failing the plans
+// of the given jobs, and recording the jobs planned
+type planStub struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	fail    map[string]bool
+	planned []string
+}
+
+// newPlanStub starts a Nomad stub failing the plans of the given jobs, to
+// be closed by the caller
+func newPlanStub(fail ...string) *planStub {
+	s := &planStub{fail: make(map[string]bool)}
+	for _, name := range fail {
+		s.fail[name] = true
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func (s *planStub) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !strings.HasPrefix(r.URL.Path, "/v1/job/") || !strings.HasSuffix(r.URL.Path, "/plan") {
+		http.NotFound(w, r)
+		return
+	}
+	name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/job/"), "/plan")
+	s.planned = append(s.planned, name)
+
+	if s.fail[name] {
+		http.Error(w, "plan failed", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(&api.JobPlanResponse{Annotations: &api.PlanAnnotations{}})
+}
+
+// batchJob returns a batch job planning a job of the same name with the stub
+func (s *planStub) batchJob(t *testing.T, name string, dependsOn ...string) *BatchJob {
+	config := api.DefaultConfig()
+	config.Address = s.URL
+	client, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDeployment(&NewDeploymentInput{
+		Client:   client,
+		Job:      &api.Job{ID: &name, Name: &name},
+		Prepared: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &BatchJob{Name: name, DependsOn: dependsOn, Deployment: d, Plan: &PlanInput{Quiet: true}}
+}
+
+func TestBatchOrder(t *testing.T) {
+	cases := []struct {
+		name  string
+		deps  map[string][]string
+		jobs  []string
+		order []string
+		err   string
+	}{
+		{
+			name:  "independent",
+			jobs:  []string{"a", "b", "c"},
+			order: []string{"a", "b", "c"},
+		},
+		{
+			name:  "chain",
+			jobs:  []string{"web", "api", "db"},
+			deps:  map[string][]string{"web": {"api"}, "api": {"db"}},
+			order: []string{"db", "api", "web"},
+		},
+		{
+			name:  "shared dependency",
+			jobs:  []string{"web", "worker", "db"},
+			deps:  map[string][]string{"web": {"db"}, "worker": {"db"}},
+			order: []string{"db", "web", "worker"},
+		},
+		{
+			name: "cycle",
+			jobs: []string{"a", "b", "c", "d"},
+			deps: map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"a"}},
+			err:  "dependency cycle between jobs: a, b, c",
+		},
+		{
+			name: "self dependency",
+			jobs: []string{"a"},
+			deps: map[string][]string{"a": {"a"}},
+			err:  "dependency cycle between jobs: a",
+		},
+		{
+			name: "unknown dependency",
+			jobs: []string{"a"},
+			deps: map[string][]string{"a": {"b"}},
+			err:  "job \"a\" depends on unknown job \"b\"",
+		},
+		{
+			name: "duplicate job",
+			jobs: []string{"a", "a"},
+			err:  "job \"a\" specified more than once",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var jobs []*BatchJob
+			for _, name := range c.jobs {
+				jobs = append(jobs, &BatchJob{Name: name, DependsOn: c.deps[name]})
+			}
+
+			b, err := NewBatch(jobs)
+			if c.err != "" {
+				if err == nil || err.Error() != c.err {
+					t.Fatalf("got error %v, want %q", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			order, err := b.Order()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(order, c.order) {
+				t.Errorf("got order %v, want %v", order, c.order)
+			}
+		})
+	}
+}
+
+func TestBatchRun(t *testing.T) {
+	s := newPlanStub("db")
+	defer s.Close()
+
+	// api shares the cache with worker, and depends on the failing db
+	b, err := NewBatch([]*BatchJob{
+		s.batchJob(t, "web", "api"),
+		s.batchJob(t, "api", "db", "cache"),
+		s.batchJob(t, "worker", "cache"),
+		s.batchJob(t, "db"),
+		s.batchJob(t, "cache"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := b.Run(context.Background(), &BatchRunInput{Parallel: 2})
+
+	want := map[string]string{
+		"web":    "skipped",
+		"api":    "skipped",
+		"worker": "no changes",
+		"db":     "failed",
+		"cache":  "no changes",
+	}
+	got := make(map[string]string)
+	var names []string
+	for _, r := range results {
+		got[r.Name] = r.Result()
+		names = append(names, r.Name)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got results %v, want %v", got, want)
+	}
+	if want := []string{"web", "api", "worker", "db", "cache"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got results in order %v, want %v", names, want)
+	}
+
+	for _, name := range s.planned {
+		if name == "api" || name == "web" {
+			t.Errorf("job \"%s\" was planned after its dependency failed", name)
+		}
+	}
+	if err := results[0].Err; err == nil || err.Error() != "dependency \"api\" failed" {
+		t.Errorf("got error %v for web, want its dependency api to have failed", err)
+	}
+}
+
+func TestBatchRunStopOnError(t *testing.T) {
+	s := newPlanStub("a")
+	defer s.Close()
+
+	b, err := NewBatch([]*BatchJob{s.batchJob(t, "a"), s.batchJob(t, "b")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := b.Run(context.Background(), &BatchRunInput{Parallel: 1, StopOnError: true})
+	if r := results[0]; r.Success || r.Skipped {
+		t.Errorf("got result %s for a, want failed", r.Result())
+	}
+	if r := results[1]; !r.Skipped {
+		t.Errorf("got result %s for b, want skipped", r.Result())
+	}
+	if !reflect.DeepEqual(s.planned, []string{"a"}) {
+		t.Errorf("planned %v, want [a]", s.planned)
+	}
+}
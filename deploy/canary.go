@@ -0,0 +1,289 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/nomad/api"
+	"github.com/hashicorp/nomad/nomad/structs"
+	"github.com/pkg/errors"
+)
+
+const (
+	// soakInterval is how often the canaries of a deployment are checked while they soak
+	soakInterval = 5 * time.Second
+
+	// soakCheckWaitTime is how long a blocking query for the Consul checks
+	// of a soaking canary's service waits for a change
+	soakCheckWaitTime = time.Minute
+
+	// soakCheckRetries is how many times in a row getting the Consul checks
+	// of a service is retried before the soak fails
+	soakCheckRetries = 3
+)
+
+// canarySoak tracks the canaries of a deployment while they soak
+// before being promoted
+type canarySoak struct {
+	groups   []string           // the task groups whose canaries are soaking, every group if empty
+	started  time.Time          // when the soak started
+	until    time.Time          // when the soak is complete
+	restarts map[string]uint64  // the task restarts of each canary allocation when the soak started
+	polls    int                // how many times the canaries were checked
+	stop     context.CancelFunc // stops watching the Consul checks of the canaries' services, if watched
+
+	mu       sync.Mutex // guards the results of watching Consul checks
+	checks   int        // how many Consul check results were observed
+	unread   int        // how many services' checks have not been read yet
+	checkErr string     // why a Consul check failed the soak, or could not be read
+}
+
+// startCanarySoak starts soaking the healthy canaries of the given task
+// groups of a deployment, or of every group if none are given
+func (d *Deployment) startCanarySoak(dep *api.Deployment, groups []string) (*canarySoak, error) {
+	s := &canarySoak{
+		groups:   groups,
+		started:  time.Now(),
+		until:    time.Now().Add(d.canarySoak),
+		restarts: make(map[string]uint64),
+	}
+
+	for _, id := range canaryAllocIDs(dep, groups) {
+		alloc, _, err := d.client.Allocations().Info(id, nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get canary allocation \"%s\"", limit(id, d.idLen))
+		}
+		s.restarts[id] = allocRestarts(alloc)
+	}
+
+	// checks are watched with blocking queries between polls, so that one
+	// that fails and recovers between polls still fails the soak
+	if d.consul != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		s.stop = cancel
+		names := d.canaryServiceNames(groups)
+		s.unread = len(names)
+		for _, name := range names {
+			go d.watchCanaryChecks(ctx, s, name)
+		}
+	}
+
+	d.log.Info("%s has healthy canaries - soaking %d canaries for %s before promotion",
+		d.soakSubject(s), len(s.restarts), d.canarySoak)
+	return s, nil
+}
+
+// checkCanarySoak checks the canaries of a soak, and returns whether the
+// soak is complete, or why it failed if any canary restarted, is no
+// longer healthy, or had a Consul check that was not passing. Once the
+// soak is complete or failed, its Consul checks are no longer watched.
+func (d *Deployment) checkCanarySoak(s *canarySoak) (done bool, reason string, err error) {
+	defer func() {
+		if done || reason != "" || err != nil {
+			s.stopChecks()
+		}
+	}()
+	s.polls++
+
+	for id, restarts := range s.restarts {
+		alloc, _, err := d.client.Allocations().Info(id, nil)
+		if err != nil {
+			return false, "", errors.Wrapf(err, "failed to get canary allocation \"%s\"", limit(id, d.idLen))
+		}
+
+		switch {
+		case alloc.ClientStatus != structs.AllocClientStatusRunning:
+			return false, fmt.Sprintf("canary allocation \"%s\" has status \"%s\"", limit(id, d.idLen), alloc.ClientStatus), nil
+		case alloc.DeploymentStatus != nil && alloc.DeploymentStatus.Healthy != nil && !*alloc.DeploymentStatus.Healthy:
+			return false, fmt.Sprintf("canary allocation \"%s\" is unhealthy", limit(id, d.idLen)), nil
+		case allocRestarts(alloc) > restarts:
+			return false, fmt.Sprintf("canary allocation \"%s\" restarted %d time(s)", limit(id, d.idLen), allocRestarts(alloc)-restarts), nil
+		}
+	}
+
+	s.mu.Lock()
+	checkErr, checks, unread := s.checkErr, s.checks, s.unread
+	s.mu.Unlock()
+	if checkErr != "" {
+		return false, checkErr, nil
+	}
+
+	if time.Now().Before(s.until) {
+		d.log.Debug("%s canaries soaking for another %s", d.soakSubject(s), time.Until(s.until).Round(time.Second))
+		return false, "", nil
+	}
+	if unread > 0 {
+		d.log.Debug("%s canaries soaked, waiting to read the Consul checks of %d service(s)", d.soakSubject(s), unread)
+		return false, "", nil
+	}
+
+	d.log.Info("canary soak of %s passed: %d canaries healthy for %s with no restarts, checked %d times (%d Consul check results passing)",
+		d.soakSubject(s), len(s.restarts), time.Since(s.started).Round(time.Second), s.polls, checks)
+	return true, "", nil
+}
+
+// stopChecks stops watching the Consul checks of a soak, if watched
+func (s *canarySoak) stopChecks() {
+	if s.stop != nil {
+		s.stop()
+	}
+}
+
+// stopCanarySoaks stops watching the Consul checks of every soak of the deployment
+func (d *Deployment) stopCanarySoaks() {
+	if d.soak != nil {
+		d.soak.stopChecks()
+	}
+	if d.staged != nil {
+		for _, s := range d.staged.soaks {
+			s.stopChecks()
+		}
+	}
+}
+
+// soakSubject describes what is being soaked in log messages
+func (d *Deployment) soakSubject(s *canarySoak) string {
+	if len(s.groups) == 0 {
+		return fmt.Sprintf("deployment \"%s\"", limit(d.deploymentID, d.idLen))
+	}
+	return fmt.Sprintf("group(s) \"%s\" of deployment \"%s\"", strings.Join(s.groups, "\", \""), limit(d.deploymentID, d.idLen))
+}
+
+// watchCanaryChecks watches the Consul checks of a service registered by
+// soaking canaries with blocking queries until the context is done or a
+// check of a canary is not passing, which fails the soak. Failing to get
+// the checks is retried, then also fails the soak, since the canaries
+// cannot be promoted without their checks.
+func (d *Deployment) watchCanaryChecks(ctx context.Context, s *canarySoak, name string) {
+	var index uint64
+	failures := 0
+	read := false
+
+	for {
+		q := &consul.QueryOptions{WaitIndex: index, WaitTime: soakCheckWaitTime}
+		checks, meta, err := d.consul.Health().Checks(name, q.WithContext(ctx))
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			failures++
+			if failures > soakCheckRetries {
+				s.failChecks(fmt.Sprintf("failed to get Consul checks of service \"%s\": %v", name, err))
+				return
+			}
+			d.log.Warning("failed to get Consul checks of service \"%s\", retrying: %v", name, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(soakInterval):
+			}
+			continue
+		}
+		failures = 0
+
+		// the index is reset if it goes backwards, such as after a restore
+		index = meta.LastIndex
+		if index < q.WaitIndex {
+			index = 0
+		}
+
+		s.mu.Lock()
+		if !read {
+			read = true
+			s.unread--
+		}
+		for _, check := range checks {
+			id := canaryForService(check.ServiceID, s.restarts)
+			if id == "" {
+				continue
+			}
+			s.checks++
+			if check.Status != consul.HealthPassing && s.checkErr == "" {
+				s.checkErr = fmt.Sprintf("canary allocation \"%s\" Consul check \"%s\" is %s", limit(id, d.idLen), check.Name, check.Status)
+			}
+		}
+		failed := s.checkErr != ""
+		s.mu.Unlock()
+		if failed {
+			return
+		}
+	}
+}
+
+// failChecks fails the soak for the given reason, unless it already failed
+func (s *canarySoak) failChecks(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.checkErr == "" {
+		s.checkErr = reason
+	}
+}
+
+// canaryServiceNames returns the sorted names of the services of the given
+// task groups, or of every group if none are given, with the task and group
+// names interpolated
+func (d *Deployment) canaryServiceNames(groups []string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, tg := range d.job.TaskGroups {
+		if len(groups) > 0 && !containsString(groups, *tg.Name) {
+			continue
+		}
+		for _, task := range tg.Tasks {
+			r := strings.NewReplacer(
+				"${NOMAD_JOB_NAME}", *d.job.Name, "${JOB}", *d.job.Name,
+				"${NOMAD_GROUP_NAME}", *tg.Name, "${TASKGROUP}", *tg.Name,
+				"${NOMAD_TASK_NAME}", task.Name, "${TASK}", task.Name,
+				"${BASE}", fmt.Sprintf("%s-%s-%s", *d.job.Name, *tg.Name, task.Name),
+			)
+			for _, service := range task.Services {
+				name := r.Replace(service.Name)
+				if !seen[name] {
+					seen[name] = true
+					names = append(names, name)
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// canaryForService returns the ID of the canary allocation that registered
+// a Consul service, which Nomad includes in the service ID, if any
+func canaryForService(serviceID string, canaries map[string]uint64) string {
+	for id := range canaries {
+		if strings.Contains(serviceID, id) {
+			return id
+		}
+	}
+	return ""
+}
+
+// canaryAllocIDs returns the IDs of the canary allocations of the given
+// task groups of a deployment, or of every group if none are given
+func canaryAllocIDs(dep *api.Deployment, groups []string) []string {
+	var ids []string
+	for name, state := range dep.TaskGroups {
+		if len(groups) > 0 && !containsString(groups, name) {
+			continue
+		}
+		ids = append(ids, state.PlacedCanaries...)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// allocRestarts returns the total task restarts of an allocation
+func allocRestarts(alloc *api.Allocation) uint64 {
+	var restarts uint64
+	for _, ts := range alloc.TaskStates {
+		restarts += ts.Restarts
+	}
+	return restarts
+}
@@ -0,0 +1,243 @@
+package deploy
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/nomad/api"
+	"github.com/pkg/errors"
+)
+
+// ExistingDeploymentInput represents the input for an existing Nomad deployment
+type ExistingDeploymentInput struct {
+	DeploymentID   string              // the ID (or unique ID prefix) of the deployment
+	JobName        string              // the name of the job whose latest deployment is used, if no DeploymentID
+	AutoPromote    bool                // whether a canary deployment should be automatically promoted
+	CanarySoak     time.Duration       // how long healthy canaries soak before being automatically promoted
+	ConsulClient   *consul.Client      // checks canary services during a soak, if set
+	PromoteOrder   [][]string          // the waves of groups automatically promoted in turn, see ParsePromoteOrder
+	PromotePause   time.Duration       // how long to wait between promoting waves of groups
+	CanaryAnalysis *AnalysisThresholds // compares canaries against stable allocations before auto-promotion, if set
+	Timeout        time.Duration       // how long to wait for the deployment to complete, zero to wait forever
+	AutoRevert     bool                // whether a timed out job should be reverted to its last stable version
+	OnFailure      FailureAction       // the action taken when the deployment fails, FailureNone if empty
+	Verbose        bool                // whether long UUIDs should be logged
+	Client         *api.Client         // the Nomad API client, one is created from the environment if nil
+}
+
+// NewExistingDeployment generates a deployment from an existing Nomad
+// deployment, either by ID or the latest deployment of a remote job
+func NewExistingDeployment(i *ExistingDeploymentInput) (*Deployment, error) {
+	if (i.DeploymentID == "") == (i.JobName == "") {
+		return nil, fmt.Errorf("must specify one of DeploymentID or JobName")
+	}
+
+	var err error
+	client := i.Client
+	if client == nil {
+		if client, err = api.NewClient(api.DefaultConfig()); err != nil {
+			return nil, err
+		}
+	}
+
+	var dep *api.Deployment
+	if i.DeploymentID != "" {
+		if dep, err = findDeployment(client, i.DeploymentID); err != nil {
+			return nil, err
+		}
+	} else {
+		dep, _, err = client.Jobs().LatestDeployment(i.JobName, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get latest deployment")
+		}
+		if dep == nil {
+			return nil, fmt.Errorf("no deployment found for job \"%s\"", i.JobName)
+		}
+	}
+
+	job, _, err := client.Jobs().Info(dep.JobID, nil)
+	if err != nil {
+		if strings.Contains(err.Error(), "404") {
+			return nil, fmt.Errorf("job \"%s\" not found on server", dep.JobID)
+		}
+		return nil, err
+	}
+
+	d := &Deployment{
+		client:       client,
+		job:          job,
+		deploymentID: dep.ID,
+		autoPromote:  i.AutoPromote,
+		canarySoak:   i.CanarySoak,
+		consul:       i.ConsulClient,
+		promoteOrder: i.PromoteOrder,
+		promotePause: i.PromotePause,
+		analysis:     i.CanaryAnalysis,
+		timeout:      i.Timeout,
+		autoRevert:   i.AutoRevert,
+		onFailure:    i.OnFailure,
+	}
+	d.setIDLength(i.Verbose)
+
+	return d, nil
+}
+
+// findDeployment returns the deployment with the given ID or unique ID prefix
+func findDeployment(client *api.Client, id string) (*api.Deployment, error) {
+	deps, _, err := client.Deployments().PrefixList(id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list deployments")
+	}
+
+	switch len(deps) {
+	case 0:
+		return nil, fmt.Errorf("no deployment found with ID prefix \"%s\"", id)
+	case 1:
+		return deps[0], nil
+	default:
+		for _, dep := range deps {
+			if dep.ID == id {
+				return dep, nil
+			}
+		}
+		return nil, fmt.Errorf("ID prefix \"%s\" matched %d deployments", id, len(deps))
+	}
+}
+
+// IsDeploymentID returns true if the given string could be
+// a Nomad deployment ID or ID prefix
+func IsDeploymentID(s string) bool {
+	if len(s) == 0 || len(s) > 36 {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdef-", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Promote promotes the canaries of the given task groups,
+// or of all task groups if none are given
+func (d *Deployment) Promote(groups []string) error {
+	if len(groups) > 0 {
+		dep, _, err := d.client.Deployments().Info(d.deploymentID, nil)
+		if err != nil {
+			return errors.Wrap(err, "failed to get deployment")
+		}
+		for _, g := range groups {
+			if _, ok := dep.TaskGroups[g]; !ok {
+				return fmt.Errorf("group \"%s\" not found in deployment \"%s\"", g, limit(d.deploymentID, d.idLen))
+			}
+		}
+	}
+
+	return d.promote(groups)
+}
+
+// promote promotes the canaries of the given task groups,
+// or of all task groups if none are given
+func (d *Deployment) promote(groups []string) error {
+	if len(groups) == 0 {
+		d.log.Info("promoting deployment \"%s\"", limit(d.deploymentID, d.idLen))
+		if _, _, err := d.client.Deployments().PromoteAll(d.deploymentID, nil); err != nil {
+			return errors.Wrap(err, "promotion failed")
+		}
+		return nil
+	}
+
+	d.log.Info("promoting group(s) \"%s\" of deployment \"%s\"", strings.Join(groups, "\", \""), limit(d.deploymentID, d.idLen))
+	if _, _, err := d.client.Deployments().PromoteGroups(d.deploymentID, groups, nil); err != nil {
+		return errors.Wrap(err, "promotion failed")
+	}
+	return nil
+}
+
+// Fail marks the deployment as failed
+func (d *Deployment) Fail() error {
+	_, err := d.fail()
+	return err
+}
+
+// fail marks the deployment as failed, and returns the version
+// the job was reverted to by Nomad, if any
+func (d *Deployment) fail() (*uint64, error) {
+	d.log.Info("failing deployment \"%s\"", limit(d.deploymentID, d.idLen))
+	resp, _, err := d.client.Deployments().Fail(d.deploymentID, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fail deployment")
+	}
+
+	if resp.RevertedJobVersion != nil {
+		d.log.Info("job \"%s\" reverted by nomad to version %d", *d.job.Name, *resp.RevertedJobVersion)
+	}
+	return resp.RevertedJobVersion, nil
+}
+
+// abortPromotion fails a deployment whose canaries are not to be promoted,
+// such as if they failed to soak, and returns an error with the reason
+func (d *Deployment) abortPromotion(dep *api.Deployment, reason string) error {
+	d.failed = true
+	d.failedVersion = &dep.JobVersion
+	if _, err := d.fail(); err != nil {
+		return err
+	}
+	return fmt.Errorf("promotion of deployment \"%s\" aborted, %s", limit(d.deploymentID, d.idLen), reason)
+}
+
+// Pause pauses or resumes the deployment
+func (d *Deployment) Pause(pause bool) error {
+	action := "resuming"
+	if pause {
+		action = "pausing"
+	}
+
+	d.log.Info("%s deployment \"%s\"", action, limit(d.deploymentID, d.idLen))
+	if _, _, err := d.client.Deployments().Pause(d.deploymentID, pause, nil); err != nil {
+		return errors.Wrapf(err, "failed %s deployment", action)
+	}
+	return nil
+}
+
+// PrintStatus writes the status of the deployment and
+// each of its task groups to standard out
+func (d *Deployment) PrintStatus() error {
+	dep, _, err := d.client.Deployments().Info(d.deploymentID, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to get deployment")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ID\t= %s\n", limit(dep.ID, d.idLen))
+	fmt.Fprintf(w, "Job ID\t= %s\n", dep.JobID)
+	fmt.Fprintf(w, "Job Version\t= %d\n", dep.JobVersion)
+	fmt.Fprintf(w, "Status\t= %s\n", dep.Status)
+	fmt.Fprintf(w, "Description\t= %s\n", dep.StatusDescription)
+	w.Flush()
+
+	if len(dep.TaskGroups) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(dep.TaskGroups))
+	for name := range dep.TaskGroups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Task Group\tPromoted\tDesired\tCanaries\tPlaced\tHealthy\tUnhealthy")
+	for _, name := range names {
+		state := dep.TaskGroups[name]
+		fmt.Fprintf(w, "%s\t%t\t%d\t%d\t%d\t%d\t%d\n", name, state.Promoted, state.DesiredTotal,
+			state.DesiredCanaries, state.PlacedAllocs, state.HealthyAllocs, state.UnhealthyAllocs)
+	}
+	return w.Flush()
+}
@@ -2,12 +2,14 @@ package deploy
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/bdclark/nomadctl/logging"
+	consul "github.com/hashicorp/consul/api"
 	"github.com/hashicorp/nomad/api"
 	"github.com/hashicorp/nomad/jobspec"
 	"github.com/hashicorp/nomad/nomad/structs"
@@ -19,45 +21,103 @@ const (
 	RedeployMetaKey = "nomadctl_redeploy"
 )
 
+// ErrJobModified is returned when a job is registered with an enforced
+// modify index that no longer matches that of the remote job
+var ErrJobModified = errors.New("job changed since plan")
+
 // Deployment is the internal representation of a Nomadctl deployment
 type Deployment struct {
-	client           *api.Client // the Nomad API client
-	job              *api.Job    // the Nomad job spec
-	enforceIndex     bool        // job will only be registered if jobModifyIndex matches the current job's index
-	jobModifyIndex   uint64      //  index to enforce job state
-	useTemplateCount bool        // whether the job will get its group counts from template rather than remote job
-	autoPromote      bool        // whether a canary job should be automatically promoted
-	deploymentID     string      // the nomad deployment id
-	idLen            int         // how long to print ids
-	needsPromotion   bool        // whether the running deployment requires a promotion to complete
-	promoted         bool        // whether a job needing promotion has been promoted
-	isRedeploy       bool        // whether this deployment is actually a re-deployment
+	client           *api.Client          // the Nomad API client
+	job              *api.Job             // the Nomad job spec
+	enforceIndex     bool                 // job will only be registered if jobModifyIndex matches the current job's index
+	jobModifyIndex   uint64               //  index to enforce job state
+	useTemplateCount bool                 // whether the job will get its group counts from template rather than remote job
+	autoPromote      bool                 // whether a canary job should be automatically promoted
+	canarySoak       time.Duration        // how long healthy canaries soak before being automatically promoted
+	soak             *canarySoak          // the canaries soaking, once they are healthy
+	promoteOrder     [][]string           // the waves of groups promoted in turn, rather than all at once
+	promotePause     time.Duration        // how long to wait between promoting waves of groups
+	staged           *stagedPromotion     // the progress of promoting groups in order
+	analysis         *AnalysisThresholds  // compares canaries against stable allocations before auto-promotion, if set
+	analysisReport   *CanaryAnalysis      // the analysis of the canaries, once analyzed
+	approvalKey      string               // the Consul key under which promotion awaits manual approval, if required
+	approved         bool                 // whether promotion of the deployment was approved
+	consul           *consul.Client       // the Consul API client, used to record the job key, check canary services during a soak and await approval
+	jobKey           string               // the full Consul job key the job is deployed from, if any
+	deploymentID     string               // the nomad deployment id
+	idLen            int                  // how long to print ids
+	needsPromotion   bool                 // whether the running deployment requires a promotion to complete
+	promoted         bool                 // whether a job needing promotion has been promoted
+	isRedeploy       bool                 // whether this deployment is actually a re-deployment
+	timeout          time.Duration        // how long to wait for the deployment to complete
+	deadline         time.Time            // when the deployment times out, zero if no timeout
+	autoRevert       bool                 // whether a timed out job should be reverted to its last stable version
+	onFailure        FailureAction        // the action taken when the registered job fails to deploy
+	failed           bool                 // whether the registered job failed to deploy
+	failedVersion    *uint64              // the job version that failed to deploy, if known
+	previousVersion  *uint64              // the job version before the job was registered, if it existed
+	revertVersion    *uint64              // the job version to revert to, if this deployment is a rollback
+	priorVersion     uint64               // the job version expected to be current when reverting
+	versions         []*api.Job           // the remote job's versions, newest first (rollbacks only)
+	evalID           string               // the id of the evaluation created when the job was registered
+	detach           bool                 // whether to return once the job is registered rather than monitor it
+	prepared         bool                 // whether the job has been prepared, or is to be registered as given
+	planResp         *api.JobPlanResponse // the response when the job was last planned
+	log              *logging.Logger      // logs messages, prefixed when deploying many jobs at once
 }
 
 // NewDeploymentInput represents the input for a new deployment
 type NewDeploymentInput struct {
-	Job              *api.Job // the Nomad Job to deploy
-	Jobspec          *[]byte  // the nomad job spec to be converted to a Nomad Job
-	EnforceIndex     bool     // job will only be registered if JobModifyIndex matches the current job's index
-	JobModifyIndex   uint64   // index to enforce job state
-	UseTemplateCount bool     // whether the job will get its group counts from template rather than remote job
-	AutoPromote      bool     // whether a canary job should be automatically promoted
-	Verbose          bool     // whether long UUIDs should be logged
+	Client           *api.Client         // the Nomad API client, one is created from the environment if nil
+	Job              *api.Job            // the Nomad Job to deploy
+	Jobspec          *[]byte             // the nomad job spec to be converted to a Nomad Job
+	JobKey           string              // the full Consul job key the job is deployed from, recorded in Consul once registered, if any
+	EnforceIndex     bool                // job will only be registered if JobModifyIndex matches the current job's index
+	JobModifyIndex   uint64              // index to enforce job state
+	UseTemplateCount bool                // whether the job will get its group counts from template rather than remote job
+	AutoPromote      bool                // whether a canary job should be automatically promoted
+	CanarySoak       time.Duration       // how long healthy canaries soak before being automatically promoted
+	ConsulClient     *consul.Client      // checks canary services during a soak, if set
+	PromoteOrder     [][]string          // the waves of groups automatically promoted in turn, see ParsePromoteOrder
+	PromotePause     time.Duration       // how long to wait between promoting waves of groups
+	CanaryAnalysis   *AnalysisThresholds // compares canaries against stable allocations before auto-promotion, if set
+	ApprovalKey      string              // the Consul key under which canaries await manual approval before promotion, if required
+	Timeout          time.Duration       // how long to wait for the deployment to complete, zero to wait forever
+	AutoRevert       bool                // whether a timed out job should be reverted to its last stable version
+	OnFailure        FailureAction       // the action taken when the job fails to deploy, FailureNone if empty
+	Detach           bool                // whether to return once the job is registered rather than monitor it
+	Prepared         bool                // whether the job is registered as given, without updating it from the remote job
+	LogPrefix        string              // prefix for log messages, such as the job key when deploying many jobs at once
+	Logger           *logging.Logger     // logs messages instead of a logger prefixed with LogPrefix, if set
+	Verbose          bool                // whether long UUIDs should be logged
 }
 
 // RedeploymentInput represents the input for a redeployment
 type RedeploymentInput struct {
+	Client         *api.Client // the Nomad API client, one is created from the environment if nil
 	JobName        string
 	TaskGroupNames []string
 	AutoPromote    bool
+	CanarySoak     time.Duration       // how long healthy canaries soak before being automatically promoted
+	ConsulClient   *consul.Client      // checks canary services during a soak, if set
+	PromoteOrder   [][]string          // the waves of groups automatically promoted in turn, see ParsePromoteOrder
+	PromotePause   time.Duration       // how long to wait between promoting waves of groups
+	CanaryAnalysis *AnalysisThresholds // compares canaries against stable allocations before auto-promotion, if set
+	Timeout        time.Duration
+	AutoRevert     bool
+	OnFailure      FailureAction   // the action taken when the job fails to deploy, FailureNone if empty
+	Detach         bool            // whether to return once the job is registered rather than monitor it
+	Logger         *logging.Logger // logs messages, if set
 	Verbose        bool
 }
 
 // NewDeployment generates a new deployment
 func NewDeployment(i *NewDeploymentInput) (d *Deployment, err error) {
-	client, err := api.NewClient(api.DefaultConfig())
-	if err != nil {
-		return
+	client := i.Client
+	if client == nil {
+		if client, err = api.NewClient(api.DefaultConfig()); err != nil {
+			return
+		}
 	}
 
 	d = &Deployment{
@@ -67,10 +127,32 @@ func NewDeployment(i *NewDeploymentInput) (d *Deployment, err error) {
 		jobModifyIndex:   i.JobModifyIndex,
 		useTemplateCount: i.UseTemplateCount,
 		autoPromote:      i.AutoPromote,
+		canarySoak:       i.CanarySoak,
+		consul:           i.ConsulClient,
+		promoteOrder:     i.PromoteOrder,
+		promotePause:     i.PromotePause,
+		analysis:         i.CanaryAnalysis,
+		approvalKey:      i.ApprovalKey,
+		jobKey:           i.JobKey,
+		timeout:          i.Timeout,
+		autoRevert:       i.AutoRevert,
+		onFailure:        i.OnFailure,
+		detach:           i.Detach,
+		prepared:         i.Prepared,
+		log:              i.Logger,
+	}
+	if d.log == nil {
+		d.log = logging.NewLogger(i.LogPrefix)
 	}
 
 	d.setIDLength(i.Verbose)
 
+	if (d.approvalKey != "" || d.jobKey != "") && d.consul == nil {
+		if d.consul, err = consul.NewClient(consul.DefaultConfig()); err != nil {
+			return nil, err
+		}
+	}
+
 	if i.Jobspec != nil && len(*i.Jobspec) > 0 {
 		if i.Job != nil {
 			return nil, fmt.Errorf("cannot specify Job and Jobspec")
@@ -88,19 +170,31 @@ func NewDeployment(i *NewDeploymentInput) (d *Deployment, err error) {
 }
 
 // ReDeploy redeploys an existing remote job
-func ReDeploy(i *RedeploymentInput) (bool, error) {
-	client, err := api.NewClient(api.DefaultConfig())
+func ReDeploy(ctx context.Context, i *RedeploymentInput) (bool, error) {
+	d, err := NewRedeployment(i)
 	if err != nil {
 		return false, err
 	}
+	return d.Deploy(ctx)
+}
+
+// NewRedeployment returns a deployment that redeploys an existing remote job
+func NewRedeployment(i *RedeploymentInput) (*Deployment, error) {
+	client := i.Client
+	if client == nil {
+		var err error
+		if client, err = api.NewClient(api.DefaultConfig()); err != nil {
+			return nil, err
+		}
+	}
 
 	// ensure job exists remotely
 	job, _, err := client.Jobs().Info(i.JobName, nil)
 	if err != nil {
 		if strings.Contains(err.Error(), "404") {
-			return false, fmt.Errorf("job \"%s\" not found on server", i.JobName)
+			return nil, fmt.Errorf("job \"%s\" not found on server", i.JobName)
 		}
-		return false, err
+		return nil, err
 	}
 
 	// use current time for value in meta key
@@ -130,13 +224,34 @@ func ReDeploy(i *RedeploymentInput) (bool, error) {
 	d.job = job
 	d.setIDLength(i.Verbose)
 	d.autoPromote = i.AutoPromote
+	d.canarySoak = i.CanarySoak
+	d.consul = i.ConsulClient
+	d.promoteOrder = i.PromoteOrder
+	d.promotePause = i.PromotePause
+	d.analysis = i.CanaryAnalysis
+	d.timeout = i.Timeout
+	d.autoRevert = i.AutoRevert
+	d.onFailure = i.OnFailure
+	d.detach = i.Detach
+	d.log = i.Logger
+
+	return &d, nil
+}
 
-	// deploy it
-	return d.Deploy()
+// Deploy performs a deployment. If the context is cancelled while the
+// deployment is being monitored, the action set with WithInterrupt is taken.
+// If the job fails to deploy, the deployment's failure action is taken.
+func (d *Deployment) Deploy(ctx context.Context) (bool, error) {
+	success, err := d.deploy(ctx)
+	return success, d.handleFailure(ctx, err)
 }
 
-// Deploy performs a deployment
-func (d *Deployment) Deploy() (success bool, err error) {
+// deploy registers the job and monitors it until it is deployed
+func (d *Deployment) deploy(ctx context.Context) (success bool, err error) {
+	if d.timeout > 0 {
+		d.deadline = time.Now().Add(d.timeout)
+	}
+
 	// validate the job first
 	resp, _, err := d.client.Jobs().Validate(d.job, nil)
 	if err != nil {
@@ -145,18 +260,9 @@ func (d *Deployment) Deploy() (success bool, err error) {
 		return false, fmt.Errorf("validation failed: %s", resp.Error)
 	}
 
-	// optionally update task group counts to reflect what's currently deployed
-	if !d.useTemplateCount {
-		if err = d.updateGroupCounts(); err != nil {
-			return false, err
-		}
-	}
-
-	// update the redeployment meta to match remote job
-	if !d.isRedeploy {
-		if err = d.updateRedeployMeta(); err != nil {
-			return false, err
-		}
+	// prepare the job the same way it was (or would have been) planned
+	if err = d.prepareJob(); err != nil {
+		return false, err
 	}
 
 	// check we have some task group counts to actually deploy
@@ -171,51 +277,55 @@ func (d *Deployment) Deploy() (success bool, err error) {
 		}
 	}
 
-	// register the job with Nomad
-	logging.Info("registering job \"%s\"", *d.job.Name)
-	opts := &api.RegisterOptions{}
-	if d.enforceIndex {
-		opts.EnforceIndex = true
-		opts.ModifyIndex = d.jobModifyIndex
+	// don't register the job if already interrupted
+	if ctx.Err() != nil {
+		return false, fmt.Errorf("interrupted before registering job \"%s\"", *d.job.Name)
 	}
-	registerResp, _, err := d.client.Jobs().RegisterOpts(d.job, opts, nil)
+
+	// register the job with Nomad
+	evalID, err := d.register()
 	if err != nil {
-		return false, errors.Wrap(err, "job register failed")
+		return false, err
 	}
-	evalID := registerResp.EvalID
+	d.evalID = evalID
+	d.recordJobKey()
 
 	// check the evaluation for failures on jobs that have eval IDs
 	if evalID != "" {
-		if ok, err := d.monitorEvalStatus(evalID); err != nil {
+		if ok, err := d.monitorEvalStatus(ctx, evalID); err != nil {
 			return false, err
 		} else if !ok {
+			d.failed = true
 			return false, fmt.Errorf("abandoning deployment due to failed/blocked evaluation(s), manual intervention required")
 		}
 	}
 
-	switch *d.job.Type {
-	case structs.JobTypeService:
+	// service jobs get a deployment ID once their evaluation completes
+	if *d.job.Type == structs.JobTypeService {
 		if eval, _, err := d.client.Evaluations().Info(evalID, nil); err == nil {
 			d.deploymentID = eval.DeploymentID
 		} else {
 			return false, err
 		}
+	}
+
+	if d.detach {
+		d.log.Info("detaching from job \"%s\"", *d.job.Name)
+		return true, nil
+	}
 
+	switch *d.job.Type {
+	case structs.JobTypeService:
 		if d.deploymentID == "" {
-			logging.Info("no deployment ID found, monitoring for running status")
-			return d.waitJobRunning()
+			d.log.Info("no deployment ID found, monitoring for running status")
+			return d.waitJobRunning(ctx)
 		}
 
-		logging.Info("monitoring deployment \"%s\"", limit(d.deploymentID, d.idLen))
-		success, err = d.monitorDeployment()
-
-		if !success {
-			err = fmt.Errorf("abandoning unsuccessful deployment, manual intervention required")
-		}
+		success, err = d.watch(ctx)
 
 	case structs.JobTypeBatch:
 		// batch jobs don't have eval IDs so just check if running
-		success, err = d.waitJobRunning()
+		success, err = d.waitJobRunning(ctx)
 
 	default:
 		success = true
@@ -224,55 +334,167 @@ func (d *Deployment) Deploy() (success bool, err error) {
 	return
 }
 
-// updateGroupCounts updates the job's task group counts with those
-// found in a remote job with the same name
-func (d *Deployment) updateGroupCounts() error {
-	remoteJob, _, err := d.client.Jobs().Info(*d.job.Name, nil)
+// Watch monitors an existing Nomad deployment until it completes. If the
+// context is cancelled, the action set with WithInterrupt is taken. If the
+// deployment fails, the deployment's failure action is taken.
+func (d *Deployment) Watch(ctx context.Context) (bool, error) {
+	if d.timeout > 0 {
+		d.deadline = time.Now().Add(d.timeout)
+	}
+	success, err := d.watch(ctx)
+	return success, d.handleFailure(ctx, err)
+}
+
+// watch monitors the Nomad deployment and returns an error if it is unsuccessful
+func (d *Deployment) watch(ctx context.Context) (bool, error) {
+	d.log.Info("monitoring deployment \"%s\"", limit(d.deploymentID, d.idLen))
+	success, err := d.monitorDeployment(ctx)
+
+	if !success && err == nil {
+		err = fmt.Errorf("abandoning unsuccessful deployment, manual intervention required")
+	}
+	return success, err
+}
+
+// JobID returns the ID of the deployment's job
+func (d *Deployment) JobID() string {
+	return *d.job.ID
+}
+
+// EvalID returns the ID of the evaluation created when the job was registered
+func (d *Deployment) EvalID() string {
+	return d.evalID
+}
+
+// DeploymentID returns the ID of the Nomad deployment, if any
+func (d *Deployment) DeploymentID() string {
+	return d.deploymentID
+}
+
+// JobModifyIndex returns the remote job's modify index when the job was
+// last planned, or the index given to enforce when registering the job
+func (d *Deployment) JobModifyIndex() uint64 {
+	return d.jobModifyIndex
+}
+
+// register registers the job with Nomad, or reverts it if this deployment
+// is a rollback, and returns the ID of the resulting evaluation
+func (d *Deployment) register() (string, error) {
+	if d.isRollback() {
+		d.log.Info("reverting job \"%s\" from version %d to version %d", *d.job.Name, d.priorVersion, *d.revertVersion)
+		resp, _, err := d.client.Jobs().Revert(*d.job.ID, *d.revertVersion, &d.priorVersion, nil)
+		if err != nil {
+			return "", errors.Wrap(err, "job revert failed")
+		}
+		prior := d.priorVersion
+		d.previousVersion = &prior
+		return resp.EvalID, nil
+	}
+
+	// record the current version, reverted to on failure if none is stable
+	if d.revertsOnFailure() || d.autoRevert {
+		remoteJob, _, err := d.client.Jobs().Info(*d.job.ID, nil)
+		switch {
+		case err == nil:
+			d.previousVersion = remoteJob.Version
+		case !strings.Contains(err.Error(), "404"):
+			return "", errors.Wrap(err, "failed to get job info")
+		}
+	}
+
+	d.log.Info("registering job \"%s\"", *d.job.Name)
+	opts := &api.RegisterOptions{}
+	if d.enforceIndex {
+		opts.EnforceIndex = true
+		opts.ModifyIndex = d.jobModifyIndex
+	}
+	resp, _, err := d.client.Jobs().RegisterOpts(d.job, opts, nil)
+	if err != nil {
+		if d.enforceIndex && strings.Contains(err.Error(), "job modify index") {
+			d.log.Debug("job register failed: %v", err)
+			return "", ErrJobModified
+		}
+		return "", errors.Wrap(err, "job register failed")
+	}
+	return resp.EvalID, nil
+}
+
+// EnforcePlanIndex causes the job to only be registered if the remote
+// job's modify index still matches the index found when it was planned
+func (d *Deployment) EnforcePlanIndex() {
+	d.enforceIndex = true
+}
+
+// prepareJob prepares the job to be planned or registered, so that a plan
+// shows exactly what will be registered. The client's region and namespace
+// are set to those of the job, then unless the job is a rollback or
+// redeployment, its task group counts (unless using the template's counts)
+// and redeploy meta are updated to match the remote job.
+func (d *Deployment) prepareJob() error {
+	d.useJobRegion()
 
+	if d.prepared || d.isRedeploy || d.isRollback() {
+		return nil
+	}
+
+	remoteJob, _, err := d.client.Jobs().Info(*d.job.Name, nil)
 	if err != nil {
 		if strings.Contains(err.Error(), "404") {
+			d.prepared = true
 			return nil // job doesn't exist
 		}
 		return err
 	}
 
-	logging.Debug("attempting to update group counts for job \"%s\" from remote job", *d.job.Name)
+	if !d.useTemplateCount {
+		d.updateGroupCounts(remoteJob)
+	}
+	d.updateRedeployMeta(remoteJob)
+
+	d.prepared = true
+	return nil
+}
+
+// useJobRegion forces the client's region and namespace to be those of the job
+func (d *Deployment) useJobRegion() {
+	if r := d.job.Region; r != nil {
+		d.client.SetRegion(*r)
+	}
+	if n := d.job.Namespace; n != nil {
+		d.client.SetNamespace(*n)
+	}
+}
+
+// updateGroupCounts updates the job's task group counts with those
+// found in the remote job
+func (d *Deployment) updateGroupCounts(remoteJob *api.Job) {
+	d.log.Debug("attempting to update group counts for job \"%s\" from remote job", *d.job.Name)
 
 	for _, rtg := range remoteJob.TaskGroups {
 		for _, tg := range d.job.TaskGroups {
-			if rtg.Name == tg.Name {
-				if tg.Count != rtg.Count {
-					logging.Info("updating count to match running job \"%s\", group \"%s\" from %v to %v",
-						d.job.Name, tg.Name, tg.Count, rtg.Count)
+			if *rtg.Name == *tg.Name {
+				if tg.Count == nil || *tg.Count != *rtg.Count {
+					d.log.Info("updating count to match running job \"%s\", group \"%s\" from %d to %d",
+						*d.job.Name, *tg.Name, intValue(tg.Count), *rtg.Count)
 					tg.Count = rtg.Count
 				}
 				break
 			}
 		}
 	}
-	return nil
 }
 
 // updateRedeployMeta updates the job's task group redeployment related
-// meta with the meta found in a remote job with the same name
-func (d *Deployment) updateRedeployMeta() error {
-	remoteJob, _, err := d.client.Jobs().Info(*d.job.Name, nil)
-
-	if err != nil {
-		if strings.Contains(err.Error(), "404") {
-			return nil // job doesn't exist
-		}
-		return err
-	}
-
-	logging.Debug("attempting to update redeploy meta for job \"%s\" from remote job", *d.job.Name)
+// meta with the meta found in the remote job
+func (d *Deployment) updateRedeployMeta(remoteJob *api.Job) {
+	d.log.Debug("attempting to update redeploy meta for job \"%s\" from remote job", *d.job.Name)
 
 	for _, rtg := range remoteJob.TaskGroups {
 		for _, tg := range d.job.TaskGroups {
 			if *rtg.Name == *tg.Name {
 
 				if val, ok := rtg.Meta[RedeployMetaKey]; ok {
-					logging.Debug("updating `%s` meta key to match remote job \"%s\", group \"%s\"",
+					d.log.Debug("updating `%s` meta key to match remote job \"%s\", group \"%s\"",
 						RedeployMetaKey, *d.job.Name, *tg.Name)
 					if tg.Meta == nil {
 						tg.Meta = make(map[string]string)
@@ -283,13 +505,11 @@ func (d *Deployment) updateRedeployMeta() error {
 			}
 		}
 	}
-
-	return nil
 }
 
 // monitorEvalStatus waits for an evaluation to complete, and returns
 // true if all allocations were placed, false if not.
-func (d *Deployment) monitorEvalStatus(id string) (bool, error) {
+func (d *Deployment) monitorEvalStatus(ctx context.Context, id string) (bool, error) {
 
 	q := &api.QueryOptions{
 		WaitIndex: 0,
@@ -297,6 +517,15 @@ func (d *Deployment) monitorEvalStatus(id string) (bool, error) {
 	}
 
 	for {
+		if ctx.Err() != nil {
+			return false, d.handleInterrupt(ctx, nil)
+		}
+		if d.timedOut() {
+			d.log.Error("timed out waiting for evaluation \"%s\" to complete", limit(id, d.idLen))
+			return false, d.handleTimeout(nil)
+		}
+		q.WaitTime = d.waitTime(ctx, 10*time.Second)
+
 		eval, meta, err := d.client.Evaluations().Info(id, q)
 		if err != nil {
 			return false, errors.Wrapf(err, "failed to get eval info")
@@ -310,7 +539,7 @@ func (d *Deployment) monitorEvalStatus(id string) (bool, error) {
 		case structs.EvalStatusComplete, structs.EvalStatusFailed, structs.EvalStatusCancelled:
 
 			if len(eval.FailedTGAllocs) == 0 {
-				logging.Info("evaluation \"%s\" finished with status \"%s\"", limit(id, d.idLen), eval.Status)
+				d.log.Info("evaluation \"%s\" finished with status \"%s\"", limit(id, d.idLen), eval.Status)
 				return true, nil
 			}
 
@@ -319,15 +548,15 @@ func (d *Deployment) monitorEvalStatus(id string) (bool, error) {
 				logMsg = append(logMsg, fmt.Sprintf("  task group %q failed to place %d allocation(s):", tg, metrics.CoalescedFailures+1))
 				logMsg = append(logMsg, formatAllocMetrics(metrics, false, strings.Repeat(" ", 4))...)
 			}
-			logging.Error(strings.Join(logMsg, "\n"))
+			d.log.Error("%s", strings.Join(logMsg, "\n"))
 
 			if eval.BlockedEval != "" {
-				logging.Error("blocked evaluation %q waiting for additional capacity to place remainder", limit(eval.BlockedEval, d.idLen))
+				d.log.Error("blocked evaluation %q waiting for additional capacity to place remainder", limit(eval.BlockedEval, d.idLen))
 			}
 			return false, nil
 
 		default:
-			logging.Info("evaluation \"%s\" has status \"%s\"", limit(id, d.idLen), eval.Status)
+			d.log.Info("evaluation \"%s\" has status \"%s\"", limit(id, d.idLen), eval.Status)
 			q.WaitIndex = meta.LastIndex
 			continue
 		}
@@ -336,41 +565,90 @@ func (d *Deployment) monitorEvalStatus(id string) (bool, error) {
 
 // monitorDeployment waits for the Nomad deployment to complete,
 // and returns true if it completed successfully, false if not.
-func (d *Deployment) monitorDeployment() (bool, error) {
+func (d *Deployment) monitorDeployment(ctx context.Context) (bool, error) {
+	defer d.stopCanarySoaks()
+
 	t := time.Now()
 	q := &api.QueryOptions{
 		WaitIndex: 0,
 		WaitTime:  time.Duration(10 * time.Second),
 	}
 
+	var dep *api.Deployment
+
 	for {
-		dep, meta, err := d.client.Deployments().Info(d.deploymentID, q)
+		if ctx.Err() != nil {
+			return false, d.handleInterrupt(ctx, dep)
+		}
+		if d.timedOut() {
+			return false, d.handleTimeout(dep)
+		}
+		q.WaitTime = d.waitTime(ctx, 10*time.Second)
+
+		// canaries are promoted automatically, or once approved
+		autoPromote := d.autoPromote || d.approvalKey != ""
+
+		// canaries that are soaking or promoted in order are checked
+		// regularly, even if the deployment is unchanged
+		soaking := d.soak != nil && !d.promoted
+		staged := autoPromote && len(d.promoteOrder) > 0 && !d.promoted
+		if soaking || staged {
+			q.WaitTime = d.waitTime(ctx, soakInterval)
+		}
+
+		var meta *api.QueryMeta
+		var err error
+		dep, meta, err = d.client.Deployments().Info(d.deploymentID, q)
 		if err != nil {
 			return false, errors.Wrap(err, "failed to get deployment")
 		}
 
-		if meta.LastIndex <= q.WaitIndex {
+		if meta.LastIndex <= q.WaitIndex && !soaking && !staged {
 			continue
 		}
 		q.WaitIndex = meta.LastIndex
 
 		switch dep.Status {
 		case structs.DeploymentStatusSuccessful:
-			logging.Info("deployment \"%s\" completed with status \"%s\"", limit(dep.ID, d.idLen), dep.Status)
+			d.log.Info("deployment \"%s\" completed with status \"%s\"", limit(dep.ID, d.idLen), dep.Status)
 			return true, nil
 
 		case structs.DeploymentStatusRunning:
-			logging.Debug("deployment \"%s\" has been running for %.1fs", limit(d.deploymentID, d.idLen), time.Since(t).Seconds())
+			d.log.Debug("deployment \"%s\" has been running for %.1fs", limit(d.deploymentID, d.idLen), time.Since(t).Seconds())
 
 			// we already auto-promoted, and are waiting on the deployment to complete
 			if d.promoted {
 				continue
 			}
 
+			// canaries are soaking, promote them once the soak is complete
+			if soaking {
+				done, reason, err := d.checkCanarySoak(d.soak)
+				switch {
+				case err != nil:
+					return false, err
+				case reason != "":
+					return false, d.abortPromotion(dep, "canary soak failed: "+reason)
+				case done:
+					if d.promoted, err = d.promoteCanaries(ctx, dep, nil); err != nil {
+						return false, err
+					}
+				}
+				continue
+			}
+
+			// groups are promoted in order as their canaries become healthy
+			if staged {
+				if err := d.promoteInOrder(ctx, dep); err != nil {
+					return false, err
+				}
+				continue
+			}
+
 			var healthy int
 
 			for name, state := range dep.TaskGroups {
-				logging.Debug("group %s: %d desired canaries, %d healthy allocs, %d desired total", name, state.DesiredCanaries, state.HealthyAllocs, state.DesiredTotal)
+				d.log.Debug("group %s: %d desired canaries, %d healthy allocs, %d desired total", name, state.DesiredCanaries, state.HealthyAllocs, state.DesiredTotal)
 
 				switch {
 				case state.DesiredCanaries == 0 && state.HealthyAllocs == state.DesiredTotal:
@@ -381,20 +659,25 @@ func (d *Deployment) monitorDeployment() (bool, error) {
 					d.needsPromotion = true
 
 				case state.UnhealthyAllocs > 0:
-					logging.Error("group \"%s\" has %d unhealthy allocations", name, state.UnhealthyAllocs)
+					d.log.Error("group \"%s\" has %d unhealthy allocations", name, state.UnhealthyAllocs)
 				}
 			}
 
 			// all desired allocs are healthy, requires promotion to complete
 			if healthy == len(dep.TaskGroups) && d.needsPromotion {
-				if d.autoPromote {
-					logging.Info("deployment \"%s\" has healthy canaries - attempting auto-promotion", limit(d.deploymentID, d.idLen))
-					if _, _, err := d.client.Deployments().PromoteAll(d.deploymentID, nil); err != nil {
-						return false, errors.Wrap(err, "promotion failed")
+				if autoPromote && d.canarySoak > 0 {
+					if d.soak, err = d.startCanarySoak(dep, nil); err != nil {
+						return false, err
+					}
+				} else if autoPromote {
+					if d.approvalKey == "" {
+						d.log.Info("deployment \"%s\" has healthy canaries - attempting auto-promotion", limit(d.deploymentID, d.idLen))
+					}
+					if d.promoted, err = d.promoteCanaries(ctx, dep, nil); err != nil {
+						return false, err
 					}
-					d.promoted = true
 				} else {
-					logging.Info("deployment \"%s\" has healthy canaries but must be manually promoted", limit(d.deploymentID, d.idLen))
+					d.log.Info("deployment \"%s\" has healthy canaries but must be manually promoted (see \"nomadctl deployment promote\")", limit(d.deploymentID, d.idLen))
 					return true, nil
 				}
 			}
@@ -402,8 +685,12 @@ func (d *Deployment) monitorDeployment() (bool, error) {
 			continue
 
 		default:
-			logging.Error("deployment \"%s\" has status \"%s\"", limit(dep.ID, d.idLen), dep.Status)
+			d.log.Error("deployment \"%s\" has status \"%s\"", limit(dep.ID, d.idLen), dep.Status)
 			d.logFailedDeployment()
+			if dep.Status == structs.DeploymentStatusFailed {
+				d.failed = true
+				d.failedVersion = &dep.JobVersion
+			}
 			return false, nil
 		}
 	}
@@ -412,7 +699,7 @@ func (d *Deployment) monitorDeployment() (bool, error) {
 func (d *Deployment) logFailedDeployment() {
 	allocs, _, err := d.client.Deployments().Allocations(d.deploymentID, nil)
 	if err != nil {
-		logging.Error("failed to get allocations for deployment \"%s\": %v", limit(d.deploymentID, d.idLen), err)
+		d.log.Error("failed to get allocations for deployment \"%s\": %v", limit(d.deploymentID, d.idLen), err)
 		return
 	}
 
@@ -442,7 +729,7 @@ func (d *Deployment) logFailedAllocEvents(allocID string, wg *sync.WaitGroup) {
 
 	alloc, _, err := d.client.Allocations().Info(allocID, nil)
 	if err != nil {
-		logging.Error("failed to get allocation \"%s\": %v", limit(allocID, d.idLen), err)
+		d.log.Error("failed to get allocation \"%s\": %v", limit(allocID, d.idLen), err)
 		return
 	}
 
@@ -457,7 +744,7 @@ func (d *Deployment) logFailedAllocEvents(allocID string, wg *sync.WaitGroup) {
 			}
 		}
 	}
-	logging.Error(strings.Join(logMsg, "\n"))
+	d.log.Error("%s", strings.Join(logMsg, "\n"))
 }
 
 // buildTaskEventMessage returns a message based
@@ -575,13 +862,22 @@ func buildTaskEventMessage(event *api.TaskEvent) (desc string) {
 // waitJobRunning checks the status of a job to ensure it's running.
 // This is helpful with batch jobs since there's really no other way
 // to determine deployment success.
-func (d *Deployment) waitJobRunning() (bool, error) {
+func (d *Deployment) waitJobRunning(ctx context.Context) (bool, error) {
 	q := &api.QueryOptions{
 		WaitIndex: 0,
 		WaitTime:  time.Duration(5 * time.Second),
 	}
 
 	for {
+		if ctx.Err() != nil {
+			return false, d.handleInterrupt(ctx, nil)
+		}
+		if d.timedOut() {
+			d.log.Error("timed out waiting for job \"%s\" to start running", *d.job.Name)
+			return false, d.handleTimeout(nil)
+		}
+		q.WaitTime = d.waitTime(ctx, 5*time.Second)
+
 		job, meta, err := d.client.Jobs().Info(*d.job.Name, q)
 		if err != nil {
 			return false, errors.Wrapf(err, "failed to get job info")
@@ -594,13 +890,14 @@ func (d *Deployment) waitJobRunning() (bool, error) {
 
 		switch *job.Status {
 		case structs.JobStatusRunning:
-			logging.Info("job \"%s\" has status \"%s\"", *job.Name, *job.Status)
+			d.log.Info("job \"%s\" has status \"%s\"", *job.Name, *job.Status)
 			return true, nil
 		case structs.JobStatusPending:
-			logging.Debug("job \"%s\" has status \"%s\"", *job.Name, *job.Status)
+			d.log.Debug("job \"%s\" has status \"%s\"", *job.Name, *job.Status)
 			continue
 		default:
-			logging.Error("job \"%s\" has status \"%s\"", *job.Name, *job.Status)
+			d.log.Error("job \"%s\" has status \"%s\"", *job.Name, *job.Status)
+			d.failed = true
 			return false, nil
 		}
 	}
@@ -655,6 +952,14 @@ func formatAllocMetrics(metrics *api.AllocationMetric, scores bool, prefix strin
 	return
 }
 
+// intValue returns the value of an int pointer, or 0 if nil
+func intValue(i *int) int {
+	if i == nil {
+		return 0
+	}
+	return *i
+}
+
 // limits the length of the string.
 func limit(s string, length int) string {
 	if len(s) < length {
@@ -664,6 +969,16 @@ func limit(s string, length int) string {
 	return s[:length]
 }
 
+// containsString returns whether a slice contains the given string
+func containsString(slice []string, s string) bool {
+	for _, v := range slice {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
 // setIDLength sets the length of UUIDs in log messages
 func (d *Deployment) setIDLength(verbose bool) {
 	if verbose {
@@ -672,3 +987,98 @@ func (d *Deployment) setIDLength(verbose bool) {
 		d.idLen = 8
 	}
 }
+
+// timedOut returns true if the deployment has a deadline that has passed
+func (d *Deployment) timedOut() bool {
+	return !d.deadline.IsZero() && time.Now().After(d.deadline)
+}
+
+// waitTime returns the wait time to use for a blocking query, shortened
+// if necessary so the query returns by the deployment deadline, and to
+// interruptWaitTime if the context can be cancelled
+func (d *Deployment) waitTime(ctx context.Context, max time.Duration) time.Duration {
+	if ctx.Done() != nil && max > interruptWaitTime {
+		max = interruptWaitTime
+	}
+	if d.deadline.IsZero() {
+		return max
+	}
+
+	remaining := time.Until(d.deadline)
+	switch {
+	case remaining < time.Second:
+		return time.Second
+	case remaining < max:
+		return remaining
+	default:
+		return max
+	}
+}
+
+// handleTimeout is called when the deployment deadline passes. It logs
+// the task groups that are not yet healthy, fails the Nomad deployment
+// (if there is one), and optionally reverts the job to its last stable version.
+func (d *Deployment) handleTimeout(dep *api.Deployment) error {
+	if dep != nil {
+		d.log.Error("deployment \"%s\" timed out after %s", limit(dep.ID, d.idLen), d.timeout)
+		d.logUnhealthyGroups(dep)
+	}
+
+	// with a failure action, the job is reverted by that action instead
+	revert := d.autoRevert
+	if d.revertsOnFailure() {
+		revert = false
+		d.failed = true
+		if dep != nil {
+			d.failedVersion = &dep.JobVersion
+		}
+	}
+
+	reverted, err := d.abort(revert)
+	if err != nil {
+		return errors.Wrap(err, "failed to abort timed out deployment")
+	}
+	if reverted {
+		return fmt.Errorf("deployment timed out after %s, job reverted", d.timeout)
+	}
+	return fmt.Errorf("deployment timed out after %s, manual intervention required", d.timeout)
+}
+
+// abort fails the Nomad deployment, if there is one, and optionally
+// reverts the job to its last stable version. It returns whether
+// the job was reverted, either by Nomad or by nomadctl.
+func (d *Deployment) abort(revert bool) (bool, error) {
+	if d.deploymentID != "" {
+		version, err := d.fail()
+		if err != nil {
+			return false, err
+		}
+		// nomad reverts the job itself if the update stanza has auto_revert set
+		if version != nil {
+			return true, nil
+		}
+	}
+
+	if revert {
+		if _, _, err := d.revertToLastStable(); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// logUnhealthyGroups logs each task group of a deployment that
+// does not have all of its desired allocations healthy
+func (d *Deployment) logUnhealthyGroups(dep *api.Deployment) {
+	for name, state := range dep.TaskGroups {
+		desired := state.DesiredTotal
+		if state.DesiredCanaries > 0 && !state.Promoted {
+			desired = state.DesiredCanaries
+		}
+		if state.HealthyAllocs < desired {
+			d.log.Error("group \"%s\" still unhealthy: %d of %d allocations healthy, %d unhealthy",
+				name, state.HealthyAllocs, desired, state.UnhealthyAllocs)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package deploy
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/pkg/errors"
+)
+
+// JobDrift describes how a rendered job differs from the job registered
+// with Nomad
+type JobDrift struct {
+	JobID   string        `json:"job_id"`
+	Drifted bool          `json:"drifted"`
+	Missing bool          `json:"missing"` // the job is not registered with Nomad
+	Fields  []*DriftField `json:"fields"`
+}
+
+// DriftField is a field that differs between the rendered and remote job
+type DriftField struct {
+	Path string `json:"path"` // e.g. TaskGroup[web].Task[app].Config.image
+	Type string `json:"type"` // Added, Deleted or Edited
+	Old  string `json:"old,omitempty"`
+	New  string `json:"new,omitempty"`
+}
+
+// Drift plans the job, prepared exactly as it would be registered, and
+// returns the fields in which the remote job differs from it
+func (d *Deployment) Drift() (*JobDrift, error) {
+	if err := d.prepareJob(); err != nil {
+		return nil, errors.Wrap(err, "failed to prepare job")
+	}
+
+	resp, _, err := d.client.Jobs().Plan(d.job, true, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "plan failed")
+	}
+	d.jobModifyIndex = resp.JobModifyIndex
+	d.planResp = resp
+
+	drift := &JobDrift{JobID: *d.job.ID, Fields: []*DriftField{}}
+	if resp.Diff == nil {
+		return drift, nil
+	}
+
+	switch resp.Diff.Type {
+	case "Added":
+		drift.Missing = true
+	case "Edited":
+		drift.Fields = append(drift.Fields, driftFields("", resp.Diff.Fields, resp.Diff.Objects)...)
+		for _, tg := range resp.Diff.TaskGroups {
+			drift.Fields = append(drift.Fields, groupDriftFields(tg)...)
+		}
+	}
+
+	drift.Drifted = drift.Missing || len(drift.Fields) > 0
+	return drift, nil
+}
+
+// groupDriftFields returns the changed fields of a task group and its tasks.
+// An added or deleted group or task is returned as a single field.
+func groupDriftFields(tg *api.TaskGroupDiff) []*DriftField {
+	path := fmt.Sprintf("TaskGroup[%s]", tg.Name)
+
+	switch tg.Type {
+	case "Added", "Deleted":
+		return []*DriftField{{Path: path, Type: tg.Type}}
+	case "Edited":
+	default:
+		return nil
+	}
+
+	out := driftFields(path, tg.Fields, tg.Objects)
+	for _, task := range tg.Tasks {
+		taskPath := fmt.Sprintf("%s.Task[%s]", path, task.Name)
+		switch task.Type {
+		case "Added", "Deleted":
+			out = append(out, &DriftField{Path: taskPath, Type: task.Type})
+		case "Edited":
+			out = append(out, driftFields(taskPath, task.Fields, task.Objects)...)
+		}
+	}
+	return out
+}
+
+// driftFields returns the changed fields and nested object fields under path
+func driftFields(path string, fields []*api.FieldDiff, objects []*api.ObjectDiff) (out []*DriftField) {
+	for _, f := range fields {
+		if f.Type == "None" {
+			continue
+		}
+		out = append(out, &DriftField{
+			Path: joinDriftPath(path, f.Name),
+			Type: f.Type,
+			Old:  f.Old,
+			New:  f.New,
+		})
+	}
+	for _, o := range objects {
+		if o.Type == "None" {
+			continue
+		}
+		out = append(out, driftFields(joinDriftPath(path, o.Name), o.Fields, o.Objects)...)
+	}
+	return out
+}
+
+// joinDriftPath appends a field or object name to a path
+func joinDriftPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,120 @@
+package deploy
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+func TestDriftFields(t *testing.T) {
+	fields := []*api.FieldDiff{
+		{Name: "Priority", Type: "Edited", Old: "50", New: "70"},
+		{Name: "Type", Type: "None", Old: "service", New: "service"},
+	}
+	objects := []*api.ObjectDiff{
+		{
+			Name: "Update",
+			Type: "Edited",
+			Fields: []*api.FieldDiff{
+				{Name: "MaxParallel", Type: "Edited", Old: "1", New: "2"},
+				{Name: "Stagger", Type: "None", Old: "30s", New: "30s"},
+			},
+		},
+		{
+			Name:   "Periodic",
+			Type:   "None",
+			Fields: []*api.FieldDiff{{Name: "Enabled", Type: "Edited", Old: "true", New: "false"}},
+		},
+		{
+			Name: "Config",
+			Type: "Edited",
+			Objects: []*api.ObjectDiff{{
+				Name:   "port_map",
+				Type:   "Added",
+				Fields: []*api.FieldDiff{{Name: "http", Type: "Added", New: "8080"}},
+			}},
+		},
+	}
+
+	cases := []struct {
+		name string
+		path string
+		want []*DriftField
+	}{
+		{
+			name: "job",
+			path: "",
+			want: []*DriftField{
+				{Path: "Priority", Type: "Edited", Old: "50", New: "70"},
+				{Path: "Update.MaxParallel", Type: "Edited", Old: "1", New: "2"},
+				{Path: "Config.port_map.http", Type: "Added", New: "8080"},
+			},
+		},
+		{
+			name: "task",
+			path: "TaskGroup[web].Task[app]",
+			want: []*DriftField{
+				{Path: "TaskGroup[web].Task[app].Priority", Type: "Edited", Old: "50", New: "70"},
+				{Path: "TaskGroup[web].Task[app].Update.MaxParallel", Type: "Edited", Old: "1", New: "2"},
+				{Path: "TaskGroup[web].Task[app].Config.port_map.http", Type: "Added", New: "8080"},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := driftFields(c.path, fields, objects)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %s, want %s", formatDriftFields(got), formatDriftFields(c.want))
+			}
+		})
+	}
+}
+
+func TestGroupDriftFields(t *testing.T) {
+	cases := []struct {
+		name string
+		tg   *api.TaskGroupDiff
+		want []*DriftField
+	}{
+		{"unchanged", &api.TaskGroupDiff{Name: "web", Type: "None"}, nil},
+		{"added", &api.TaskGroupDiff{Name: "web", Type: "Added"}, []*DriftField{{Path: "TaskGroup[web]", Type: "Added"}}},
+		{
+			"edited",
+			&api.TaskGroupDiff{
+				Name:   "web",
+				Type:   "Edited",
+				Fields: []*api.FieldDiff{{Name: "Count", Type: "Edited", Old: "2", New: "3"}},
+				Tasks: []*api.TaskDiff{
+					{Name: "app", Type: "Deleted"},
+					{Name: "sidecar", Type: "Edited", Fields: []*api.FieldDiff{{Name: "Driver", Type: "Edited", Old: "exec", New: "docker"}}},
+					{Name: "log", Type: "None", Fields: []*api.FieldDiff{{Name: "Driver", Type: "None", Old: "exec", New: "exec"}}},
+				},
+			},
+			[]*DriftField{
+				{Path: "TaskGroup[web].Count", Type: "Edited", Old: "2", New: "3"},
+				{Path: "TaskGroup[web].Task[app]", Type: "Deleted"},
+				{Path: "TaskGroup[web].Task[sidecar].Driver", Type: "Edited", Old: "exec", New: "docker"},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := groupDriftFields(c.tg)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %s, want %s", formatDriftFields(got), formatDriftFields(c.want))
+			}
+		})
+	}
+}
+
+// formatDriftFields formats drift fields for test failures
+func formatDriftFields(fields []*DriftField) []DriftField {
+	out := make([]DriftField, 0, len(fields))
+	for _, f := range fields {
+		out = append(out, *f)
+	}
+	return out
+}
@@ -0,0 +1,108 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/pkg/errors"
+)
+
+// interruptWaitTime is the longest a blocking query waits while the
+// deployment can be interrupted, so an interrupt is handled well before a
+// SIGKILL following SIGTERM
+const interruptWaitTime = 2 * time.Second
+
+// InterruptAction is the action taken when a deployment is interrupted
+type InterruptAction string
+
+const (
+	// InterruptDetach stops monitoring, leaving the Nomad deployment running
+	InterruptDetach InterruptAction = "detach"
+
+	// InterruptFail fails the Nomad deployment
+	InterruptFail InterruptAction = "fail"
+
+	// InterruptRevert fails the Nomad deployment and reverts
+	// the job to its last stable version
+	InterruptRevert InterruptAction = "revert"
+)
+
+// InterruptActions lists the valid interrupt actions
+var InterruptActions = []InterruptAction{InterruptDetach, InterruptFail, InterruptRevert}
+
+// ParseInterruptAction returns the InterruptAction matching the given string
+func ParseInterruptAction(s string) (InterruptAction, error) {
+	for _, a := range InterruptActions {
+		if string(a) == s {
+			return a, nil
+		}
+	}
+	return "", fmt.Errorf("invalid interrupt action \"%s\", must be one of %v", s, InterruptActions)
+}
+
+type interruptKey struct{}
+
+// interrupt holds the action to take once a context is interrupted
+type interrupt struct {
+	sync.Mutex
+	action InterruptAction
+}
+
+// WithInterrupt returns a copy of the parent context along with a function
+// that cancels it. The action passed to the function is taken by any
+// deployment being monitored with the context. If the context is cancelled
+// any other way, deployments detach.
+func WithInterrupt(parent context.Context) (context.Context, func(InterruptAction)) {
+	i := &interrupt{action: InterruptDetach}
+	ctx, cancel := context.WithCancel(context.WithValue(parent, interruptKey{}, i))
+
+	return ctx, func(a InterruptAction) {
+		i.Lock()
+		i.action = a
+		i.Unlock()
+		cancel()
+	}
+}
+
+// interruptAction returns the action to take for an interrupted context
+func interruptAction(ctx context.Context) InterruptAction {
+	i, ok := ctx.Value(interruptKey{}).(*interrupt)
+	if !ok {
+		return InterruptDetach
+	}
+
+	i.Lock()
+	defer i.Unlock()
+	return i.action
+}
+
+// handleInterrupt is called when the deployment's context is cancelled,
+// and takes the action set with WithInterrupt
+func (d *Deployment) handleInterrupt(ctx context.Context, dep *api.Deployment) error {
+	action := interruptAction(ctx)
+
+	if action == InterruptDetach {
+		if d.deploymentID != "" {
+			d.log.Warning("detaching from deployment \"%s\", use \"nomadctl deploy watch %s\" to re-attach",
+				limit(d.deploymentID, d.idLen), d.deploymentID)
+		}
+		return fmt.Errorf("interrupted, detached from job \"%s\"", *d.job.Name)
+	}
+
+	d.log.Warning("interrupted, attempting to %s job \"%s\"", action, *d.job.Name)
+	if dep != nil {
+		d.logUnhealthyGroups(dep)
+	}
+
+	reverted, err := d.abort(action == InterruptRevert)
+	if err != nil {
+		return errors.Wrap(err, "failed to abort interrupted deployment")
+	}
+	if reverted {
+		return fmt.Errorf("interrupted, job \"%s\" reverted", *d.job.Name)
+	}
+	return fmt.Errorf("interrupted, deployment of job \"%s\" failed", *d.job.Name)
+}
@@ -2,6 +2,7 @@ package deploy
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"sort"
 	"strings"
@@ -19,21 +20,29 @@ import (
 	from the Nomad source at https://github.com/hashicorp/nomad/blob/master/command/plan.go
 */
 
-// Plan executes a Nomad plan, writes the output to standard out, and
-// returns whether any allocations will be created/destroyed
-func (d *Deployment) Plan(quiet, verbose, diff, noColor bool) (bool, error) {
-	// force the region to be that of the job.
-	if r := d.job.Region; r != nil {
-		d.client.SetRegion(*r)
-	}
+// PlanInput represents the input for a job plan
+type PlanInput struct {
+	Quiet   bool       // whether to only return whether changes are planned, without output
+	Verbose bool       // whether added or deleted groups and tasks are expanded in the diff
+	Diff    bool       // whether the job diff is included in text output
+	NoColor bool       // whether to disable colorized text output
+	Format  PlanFormat // the output format, text if empty
+	Out     io.Writer  // where to write the output, standard out if nil
+}
 
-	// force the namespace to be that of the job.
-	if n := d.job.Namespace; n != nil {
-		d.client.SetNamespace(*n)
+// Plan executes a Nomad plan, writes the output in the requested format,
+// and returns whether any allocations will be created/destroyed
+func (d *Deployment) Plan(i *PlanInput) (bool, error) {
+	if i == nil {
+		i = &PlanInput{}
+	}
+	out := i.Out
+	if out == nil {
+		out = os.Stdout
 	}
 
-	if !d.isRedeploy {
-		d.updateRedeployMeta()
+	if err := d.prepareJob(); err != nil {
+		return false, errors.Wrap(err, "failed to prepare job")
 	}
 
 	resp, _, err := d.client.Jobs().Plan(d.job, true, nil)
@@ -41,38 +50,59 @@ func (d *Deployment) Plan(quiet, verbose, diff, noColor bool) (bool, error) {
 		return false, errors.Wrap(err, "plan failed")
 	}
 	d.jobModifyIndex = resp.JobModifyIndex
+	d.planResp = resp
 
-	if quiet {
+	if i.Quiet {
 		// don't display, just return whether changes planned
 		return areChangesPlanned(resp), nil
 	}
 
-	colorize := &colorstring.Colorize{
-		Colors:  colorstring.DefaultColors,
-		Disable: noColor || !terminal.IsTerminal(int(os.Stdout.Fd())),
-		Reset:   true,
+	switch i.Format {
+	case PlanFormatJSON:
+		err = writePlanJSON(out, resp, d.job)
+	case PlanFormatMarkdown:
+		err = writePlanMarkdown(out, resp, d.job, i.Verbose)
+	default:
+		colorize := &colorstring.Colorize{
+			Colors:  colorstring.DefaultColors,
+			Disable: i.NoColor || !isTerminal(out),
+			Reset:   true,
+		}
+		writePlanText(out, resp, d.job, colorize, i.Diff, i.Verbose)
+	}
+	if err != nil {
+		return false, err
 	}
 
+	// check for allocation changes and return accordingly
+	return areChangesPlanned(resp), nil
+}
+
+// writePlanText writes the plan as colorized text, the same as the Nomad CLI
+func writePlanText(out io.Writer, resp *api.JobPlanResponse, job *api.Job, colorize *colorstring.Colorize, diff, verbose bool) {
 	// print the job diff
 	if diff {
-		fmt.Println(fmt.Sprintf("%s\n", colorize.Color(strings.TrimSpace(formatJobDiff(resp.Diff, verbose)))))
+		fmt.Fprintln(out, fmt.Sprintf("%s\n", colorize.Color(strings.TrimSpace(formatJobDiff(resp.Diff, verbose)))))
 	}
 
 	// print the scheduler dry-run output
-	fmt.Println(colorize.Color("[bold]Scheduler dry-run:[reset]"))
-	fmt.Println(colorize.Color(formatDryRun(resp, d.job)))
-	fmt.Println()
+	fmt.Fprintln(out, colorize.Color("[bold]Scheduler dry-run:[reset]"))
+	fmt.Fprintln(out, colorize.Color(formatDryRun(resp, job)))
+	fmt.Fprintln(out)
 
 	// print any warnings
 	if resp.Warnings != "" {
-		fmt.Println(colorize.Color(fmt.Sprintf("[bold][yellow]Job Warnings:\n%s[reset]\n", resp.Warnings)))
+		fmt.Fprintln(out, colorize.Color(fmt.Sprintf("[bold][yellow]Job Warnings:\n%s[reset]\n", resp.Warnings)))
 	}
 
 	// print job index info
-	fmt.Println(colorize.Color(fmt.Sprintf("[reset][bold]Job Modify Index: %d[reset]", resp.JobModifyIndex)))
+	fmt.Fprintln(out, colorize.Color(fmt.Sprintf("[reset][bold]Job Modify Index: %d[reset]", resp.JobModifyIndex)))
+}
 
-	// check for allocation changes and return accordingly
-	return areChangesPlanned(resp), nil
+// isTerminal returns true if the writer is a terminal
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && terminal.IsTerminal(int(f.Fd()))
 }
 
 // areChangesPlanned checks a job plan for allocation changes and returns
@@ -444,3 +474,29 @@ func formatTime(t time.Time) string {
 func formatTimeDifference(first, second time.Time, d time.Duration) string {
 	return second.Truncate(d).Sub(first.Truncate(d)).String()
 }
+
+// WriteStopPlan writes what stopping a job changes in the same format as
+// a plan: the job and each of its task groups as deleted, with how many
+// allocations of each group are destroyed
+func WriteStopPlan(out io.Writer, job *api.Job, summary *api.JobSummary) {
+	diff := &api.JobDiff{Type: "Deleted", ID: *job.ID}
+	for _, tg := range job.TaskGroups {
+		var stop uint64
+		if summary != nil {
+			s := summary.Summary[*tg.Name]
+			stop = uint64(s.Running + s.Starting)
+		}
+		diff.TaskGroups = append(diff.TaskGroups, &api.TaskGroupDiff{
+			Type:    "Deleted",
+			Name:    *tg.Name,
+			Updates: map[string]uint64{scheduler.UpdateTypeDestroy: stop},
+		})
+	}
+
+	colorize := &colorstring.Colorize{
+		Colors:  colorstring.DefaultColors,
+		Disable: !isTerminal(out),
+		Reset:   true,
+	}
+	fmt.Fprintln(out, colorize.Color(strings.TrimSpace(formatJobDiff(diff, false))))
+}
@@ -0,0 +1,118 @@
+package deploy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/pkg/errors"
+)
+
+// PlanFile is a saved job plan that can be deployed exactly as it was planned
+type PlanFile struct {
+	Job            *api.Job               `json:"job"`              // the job as planned
+	JobModifyIndex uint64                 `json:"job_modify_index"` // the remote job's modify index when planned
+	DiffHash       string                 `json:"diff_hash"`        // hash of the planned job diff
+	JobKey         string                 `json:"job_key"`          // the full Consul job key planned from, if any
+	TemplateSource string                 `json:"template_source"`  // the source of the job's template
+	Jobspec        string                 `json:"jobspec"`          // the rendered template
+	Config         map[string]interface{} `json:"config"`           // the resolved nomadctl config
+	Created        time.Time              `json:"created"`
+}
+
+// NewPlanFile returns a plan file for a deployment that has been planned,
+// from the given full job key if any
+func (d *Deployment) NewPlanFile(jobKey, source string, jobspec []byte, config map[string]interface{}) (*PlanFile, error) {
+	if d.planResp == nil {
+		return nil, fmt.Errorf("job \"%s\" has not been planned", *d.job.Name)
+	}
+
+	hash, err := hashDiff(d.planResp.Diff)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PlanFile{
+		Job:            d.job,
+		JobModifyIndex: d.jobModifyIndex,
+		DiffHash:       hash,
+		JobKey:         jobKey,
+		TemplateSource: source,
+		Jobspec:        string(jobspec),
+		Config:         config,
+		Created:        time.Now().UTC(),
+	}, nil
+}
+
+// ReadPlanFile reads a plan file written with PlanFile.Write
+func ReadPlanFile(path string) (*PlanFile, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read plan file")
+	}
+
+	var p PlanFile
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse plan file \"%s\"", path)
+	}
+	if p.Job == nil || p.Job.Name == nil || p.DiffHash == "" {
+		return nil, fmt.Errorf("plan file \"%s\" is missing its job or diff hash", path)
+	}
+	return &p, nil
+}
+
+// Write writes the plan file as JSON. The file is only readable by its
+// owner since the resolved config may contain template getter options.
+func (p *PlanFile) Write(path string) error {
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode plan file")
+	}
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		return errors.Wrap(err, "failed to write plan file")
+	}
+	return nil
+}
+
+// VerifyPlan plans the deployment's job again and returns ErrJobModified
+// if either the remote job's modify index or the resulting diff no longer
+// match those of the saved plan
+func (d *Deployment) VerifyPlan(diffHash string) error {
+	d.useJobRegion()
+
+	resp, _, err := d.client.Jobs().Plan(d.job, true, nil)
+	if err != nil {
+		return errors.Wrap(err, "plan failed")
+	}
+
+	if resp.JobModifyIndex != d.jobModifyIndex {
+		d.log.Debug("job \"%s\" modify index is %d, planned with %d", *d.job.Name, resp.JobModifyIndex, d.jobModifyIndex)
+		return ErrJobModified
+	}
+
+	hash, err := hashDiff(resp.Diff)
+	if err != nil {
+		return err
+	}
+	if hash != diffHash {
+		d.log.Debug("job \"%s\" diff hash is %s, planned with %s", *d.job.Name, hash, diffHash)
+		return ErrJobModified
+	}
+
+	d.planResp = resp
+	return nil
+}
+
+// hashDiff returns the hex-encoded SHA256 hash of a job diff
+func hashDiff(diff *api.JobDiff) (string, error) {
+	b, err := json.Marshal(diff)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode job diff")
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
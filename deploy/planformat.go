@@ -0,0 +1,205 @@
+package deploy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/mitchellh/colorstring"
+	"github.com/pkg/errors"
+)
+
+// PlanFormat is the output format of a job plan
+type PlanFormat string
+
+const (
+	// PlanFormatText is colorized text, the same as the Nomad CLI
+	PlanFormatText PlanFormat = "text"
+
+	// PlanFormatJSON is structured JSON
+	PlanFormatJSON PlanFormat = "json"
+
+	// PlanFormatMarkdown is markdown, suitable for a pull request comment
+	PlanFormatMarkdown PlanFormat = "markdown"
+)
+
+// PlanFormats lists the valid plan output formats
+var PlanFormats = []PlanFormat{PlanFormatText, PlanFormatJSON, PlanFormatMarkdown}
+
+// ParsePlanFormat returns the PlanFormat matching the given string
+func ParsePlanFormat(s string) (PlanFormat, error) {
+	for _, f := range PlanFormats {
+		if string(f) == s {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("invalid plan format \"%s\", must be one of %v", s, PlanFormats)
+}
+
+// planOutput is the JSON representation of a job plan
+type planOutput struct {
+	JobID              string                      `json:"job_id"`
+	JobModifyIndex     uint64                      `json:"job_modify_index"`
+	ChangesPlanned     bool                        `json:"changes_planned"`
+	Diff               *api.JobDiff                `json:"diff"`
+	DesiredUpdates     map[string]*groupUpdates    `json:"desired_updates"`
+	FailedPlacements   map[string]*failedPlacement `json:"failed_placements"`
+	Warnings           []string                    `json:"warnings"`
+	NextPeriodicLaunch *time.Time                  `json:"next_periodic_launch,omitempty"`
+}
+
+// groupUpdates are the updates the scheduler will make to a task group
+type groupUpdates struct {
+	Create            uint64 `json:"create"`
+	Destroy           uint64 `json:"destroy"`
+	Migrate           uint64 `json:"migrate"`
+	InPlaceUpdate     uint64 `json:"in_place_update"`
+	DestructiveUpdate uint64 `json:"destructive_update"`
+	Canary            uint64 `json:"canary"`
+	Ignore            uint64 `json:"ignore"`
+}
+
+// failedPlacement describes the allocations of a task group that could not be placed
+type failedPlacement struct {
+	Count   int                   `json:"count"`
+	Reasons []string              `json:"reasons"`
+	Metrics *api.AllocationMetric `json:"metrics"`
+}
+
+// newPlanOutput converts a plan response to its JSON representation
+func newPlanOutput(resp *api.JobPlanResponse, job *api.Job) *planOutput {
+	p := &planOutput{
+		JobID:            *job.ID,
+		JobModifyIndex:   resp.JobModifyIndex,
+		ChangesPlanned:   areChangesPlanned(resp),
+		Diff:             resp.Diff,
+		DesiredUpdates:   make(map[string]*groupUpdates),
+		FailedPlacements: make(map[string]*failedPlacement),
+		Warnings:         splitWarnings(resp.Warnings),
+	}
+
+	if resp.Annotations != nil {
+		for tg, u := range resp.Annotations.DesiredTGUpdates {
+			p.DesiredUpdates[tg] = &groupUpdates{
+				Create:            u.Place,
+				Destroy:           u.Stop,
+				Migrate:           u.Migrate,
+				InPlaceUpdate:     u.InPlaceUpdate,
+				DestructiveUpdate: u.DestructiveUpdate,
+				Canary:            u.Canary,
+				Ignore:            u.Ignore,
+			}
+		}
+	}
+
+	for tg, metrics := range resp.FailedTGAllocs {
+		var reasons []string
+		for _, line := range formatAllocMetrics(metrics, false, "") {
+			reasons = append(reasons, strings.TrimPrefix(line, "* "))
+		}
+		p.FailedPlacements[tg] = &failedPlacement{
+			Count:   metrics.CoalescedFailures + 1,
+			Reasons: reasons,
+			Metrics: metrics,
+		}
+	}
+
+	if next := resp.NextPeriodicLaunch; !next.IsZero() && !job.IsParameterized() {
+		p.NextPeriodicLaunch = &next
+	}
+
+	return p
+}
+
+// writePlanJSON writes the plan as indented JSON
+func writePlanJSON(out io.Writer, resp *api.JobPlanResponse, job *api.Job) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(newPlanOutput(resp, job)); err != nil {
+		return errors.Wrap(err, "failed to encode plan")
+	}
+	return nil
+}
+
+// writePlanMarkdown writes the plan as markdown, with a summary table of
+// task group updates followed by any failed placements, warnings and the
+// job diff in a collapsible block
+func writePlanMarkdown(out io.Writer, resp *api.JobPlanResponse, job *api.Job, verbose bool) error {
+	p := newPlanOutput(resp, job)
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "### Nomad plan for job `%s`\n\n", p.JobID)
+	if p.ChangesPlanned {
+		b.WriteString("Allocations will be created or destroyed.\n\n")
+	} else {
+		b.WriteString("No allocations will be created or destroyed.\n\n")
+	}
+
+	groups := make([]string, 0, len(p.DesiredUpdates))
+	for tg := range p.DesiredUpdates {
+		groups = append(groups, tg)
+	}
+	sort.Strings(groups)
+
+	if len(groups) > 0 {
+		b.WriteString("| Task Group | Create | Destroy | Migrate | In-Place | Destructive | Canary | Ignore |\n")
+		b.WriteString("|------------|--------|---------|---------|----------|-------------|--------|--------|\n")
+		for _, tg := range groups {
+			u := p.DesiredUpdates[tg]
+			fmt.Fprintf(&b, "| `%s` | %d | %d | %d | %d | %d | %d | %d |\n", tg,
+				u.Create, u.Destroy, u.Migrate, u.InPlaceUpdate, u.DestructiveUpdate, u.Canary, u.Ignore)
+		}
+		b.WriteString("\n")
+	}
+
+	if len(p.FailedPlacements) > 0 {
+		b.WriteString("**Failed placements:**\n\n")
+		for _, tg := range sortedTaskGroupFromMetrics(resp.FailedTGAllocs) {
+			f := p.FailedPlacements[tg]
+			fmt.Fprintf(&b, "- `%s` failed to place %d allocation(s)\n", tg, f.Count)
+			for _, reason := range f.Reasons {
+				fmt.Fprintf(&b, "  - %s\n", reason)
+			}
+		}
+		b.WriteString("\n")
+	}
+
+	if len(p.Warnings) > 0 {
+		b.WriteString("**Warnings:**\n\n")
+		for _, w := range p.Warnings {
+			fmt.Fprintf(&b, "- %s\n", w)
+		}
+		b.WriteString("\n")
+	}
+
+	if p.Diff != nil {
+		plain := &colorstring.Colorize{Colors: colorstring.DefaultColors, Disable: true}
+		b.WriteString("<details><summary>Job diff</summary>\n\n```diff\n")
+		b.WriteString(strings.TrimSpace(plain.Color(formatJobDiff(p.Diff, verbose))))
+		b.WriteString("\n```\n\n</details>\n\n")
+	}
+
+	fmt.Fprintf(&b, "Job Modify Index: %d\n", p.JobModifyIndex)
+
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+// splitWarnings splits the warnings of a plan, which Nomad formats as
+// "N warning(s):" followed by a bulleted list, into individual warnings
+func splitWarnings(warnings string) []string {
+	out := []string{}
+	for _, w := range strings.Split(warnings, "\n") {
+		w = strings.TrimSpace(w)
+		if w == "" || strings.HasSuffix(w, "warning(s):") {
+			continue
+		}
+		out = append(out, strings.TrimPrefix(w, "* "))
+	}
+	return out
+}
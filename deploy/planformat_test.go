@@ -0,0 +1,30 @@
+package deploy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitWarnings(t *testing.T) {
+	cases := []struct {
+		name     string
+		warnings string
+		want     []string
+	}{
+		{"none", "", []string{}},
+		{
+			"nomad format",
+			"2 warning(s):\n\n* Group \"web\" has warnings\n* Task \"app\" has warnings\n",
+			[]string{"Group \"web\" has warnings", "Task \"app\" has warnings"},
+		},
+		{"unbulleted", "  job has a warning  ", []string{"job has a warning"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := splitWarnings(c.warnings); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
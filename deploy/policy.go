@@ -0,0 +1,231 @@
+package deploy
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Policy conditions a job plan can be checked for
+const (
+	// PolicyDestructiveUpdate matches task groups with destructive updates
+	PolicyDestructiveUpdate = "destructive_update"
+
+	// PolicyImageChangeWithoutMeta matches tasks whose "image" config
+	// changes without any job, group or task meta changing along with it
+	PolicyImageChangeWithoutMeta = "image_change_without_meta"
+
+	// PolicyCountDecrease matches task groups whose count decreases
+	PolicyCountDecrease = "count_decrease"
+
+	// PolicyPlacementFailure matches task groups with allocations that could not be placed
+	PolicyPlacementFailure = "placement_failure"
+)
+
+// PolicyConditions lists the valid policy rule conditions
+var PolicyConditions = []string{PolicyDestructiveUpdate, PolicyImageChangeWithoutMeta, PolicyCountDecrease, PolicyPlacementFailure}
+
+// Policy is a set of rules that a job plan must not violate
+type Policy struct {
+	Rules []*PolicyRule `yaml:"rules"`
+}
+
+// PolicyRule fails a job plan on a condition, optionally limited
+// to specific task groups, with its own exit code
+type PolicyRule struct {
+	Name      string   `yaml:"name"`
+	Condition string   `yaml:"condition"`
+	Groups    []string `yaml:"groups"`
+	ExitCode  int      `yaml:"exit_code"`
+}
+
+// PolicyViolation is a violation of a policy rule by a job plan
+type PolicyViolation struct {
+	Rule    *PolicyRule
+	Group   string
+	Message string
+}
+
+// ParsePolicy parses a YAML (or JSON) policy document. Rules without an
+// exit code are given one based on their position, starting at 2.
+func ParsePolicy(b []byte) (*Policy, error) {
+	var p Policy
+	if err := yaml.UnmarshalStrict(b, &p); err != nil {
+		return nil, errors.Wrap(err, "failed to parse plan policy")
+	}
+
+	for i, rule := range p.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+
+		valid := false
+		for _, c := range PolicyConditions {
+			if rule.Condition == c {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("policy rule \"%s\" has invalid condition \"%s\", must be one of %v", rule.Name, rule.Condition, PolicyConditions)
+		}
+
+		if rule.ExitCode == 0 {
+			rule.ExitCode = i + 2
+		}
+		// 0, 1 and 255 already mean no changes, changes and error
+		if rule.ExitCode < 2 || rule.ExitCode > 254 {
+			return nil, fmt.Errorf("policy rule \"%s\" has invalid exit code %d, must be between 2 and 254", rule.Name, rule.ExitCode)
+		}
+	}
+
+	return &p, nil
+}
+
+// ExitCode returns the exit code of the first violated rule, in the order
+// the rules are defined, or 0 if there are no violations
+func (p *Policy) ExitCode(violations []*PolicyViolation) int {
+	for _, rule := range p.Rules {
+		for _, v := range violations {
+			if v.Rule == rule {
+				return rule.ExitCode
+			}
+		}
+	}
+	return 0
+}
+
+// CheckPolicy checks the deployment's most recent plan against a policy
+// and returns any violations
+func (d *Deployment) CheckPolicy(p *Policy) ([]*PolicyViolation, error) {
+	if d.planResp == nil {
+		return nil, fmt.Errorf("job \"%s\" has not been planned", *d.job.Name)
+	}
+
+	var violations []*PolicyViolation
+	for _, rule := range p.Rules {
+		violations = append(violations, rule.check(d.planResp)...)
+	}
+	return violations, nil
+}
+
+// check returns the violations of the rule by a plan
+func (r *PolicyRule) check(resp *api.JobPlanResponse) (violations []*PolicyViolation) {
+	violate := func(group, format string, a ...interface{}) {
+		violations = append(violations, &PolicyViolation{
+			Rule:    r,
+			Group:   group,
+			Message: fmt.Sprintf(format, a...),
+		})
+	}
+
+	switch r.Condition {
+	case PolicyDestructiveUpdate:
+		if resp.Annotations == nil {
+			return
+		}
+		for _, tg := range sortedGroupUpdates(resp.Annotations.DesiredTGUpdates) {
+			if n := resp.Annotations.DesiredTGUpdates[tg].DestructiveUpdate; n > 0 && r.appliesTo(tg) {
+				violate(tg, "group \"%s\" has %d destructive update(s)", tg, n)
+			}
+		}
+
+	case PolicyPlacementFailure:
+		for _, tg := range sortedTaskGroupFromMetrics(resp.FailedTGAllocs) {
+			if r.appliesTo(tg) {
+				violate(tg, "group \"%s\" failed to place %d allocation(s)", tg, resp.FailedTGAllocs[tg].CoalescedFailures+1)
+			}
+		}
+
+	case PolicyCountDecrease:
+		if resp.Diff == nil {
+			return
+		}
+		for _, tg := range resp.Diff.TaskGroups {
+			if !r.appliesTo(tg.Name) {
+				continue
+			}
+			for _, f := range tg.Fields {
+				if f.Name != "Count" || f.Type != "Edited" {
+					continue
+				}
+				oldCount, errOld := strconv.Atoi(f.Old)
+				newCount, errNew := strconv.Atoi(f.New)
+				if errOld == nil && errNew == nil && newCount < oldCount {
+					violate(tg.Name, "group \"%s\" count decreases from %d to %d", tg.Name, oldCount, newCount)
+				}
+			}
+		}
+
+	case PolicyImageChangeWithoutMeta:
+		if resp.Diff == nil || metaChanged(resp.Diff.Fields, resp.Diff.Objects) {
+			return
+		}
+		for _, tg := range resp.Diff.TaskGroups {
+			if !r.appliesTo(tg.Name) || metaChanged(tg.Fields, tg.Objects) {
+				continue
+			}
+			for _, task := range tg.Tasks {
+				if metaChanged(task.Fields, task.Objects) {
+					continue
+				}
+				for _, obj := range task.Objects {
+					if obj.Name != "Config" {
+						continue
+					}
+					for _, f := range obj.Fields {
+						if f.Name == "image" && f.Type == "Edited" {
+							violate(tg.Name, "group \"%s\" task \"%s\" image changes from %q to %q without a meta change",
+								tg.Name, task.Name, f.Old, f.New)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// appliesTo returns true if the rule applies to the given task group
+func (r *PolicyRule) appliesTo(group string) bool {
+	if len(r.Groups) == 0 {
+		return true
+	}
+	for _, g := range r.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// metaChanged returns true if a diff's fields or objects include a meta
+// change, which Nomad represents as fields named "Meta[key]"
+func metaChanged(fields []*api.FieldDiff, objects []*api.ObjectDiff) bool {
+	for _, f := range fields {
+		if strings.HasPrefix(f.Name, "Meta[") && f.Type != "None" {
+			return true
+		}
+	}
+	for _, obj := range objects {
+		if obj.Name == "Meta" && obj.Type != "None" {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedGroupUpdates(updates map[string]*api.DesiredUpdates) []string {
+	tgs := make([]string, 0, len(updates))
+	for tg := range updates {
+		tgs = append(tgs, tg)
+	}
+	sort.Strings(tgs)
+	return tgs
+}
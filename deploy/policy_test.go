@@ -0,0 +1,191 @@
+package deploy
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+func TestParsePolicy(t *testing.T) {
+	cases := []struct {
+		name  string
+		doc   string
+		codes []int
+		err   bool
+	}{
+		{
+			name:  "codes by position",
+			doc:   "rules:\n  - condition: destructive_update\n  - condition: count_decrease\n",
+			codes: []int{2, 3},
+		},
+		{
+			name:  "explicit codes",
+			doc:   "rules:\n  - condition: destructive_update\n    exit_code: 10\n  - condition: count_decrease\n",
+			codes: []int{10, 3},
+		},
+		{
+			name:  "json",
+			doc:   `{"rules": [{"condition": "placement_failure", "exit_code": 254}]}`,
+			codes: []int{254},
+		},
+		{
+			name: "exit code 1",
+			doc:  "rules:\n  - condition: destructive_update\n    exit_code: 1\n",
+			err:  true,
+		},
+		{
+			name: "exit code 255",
+			doc:  "rules:\n  - condition: destructive_update\n    exit_code: 255\n",
+			err:  true,
+		},
+		{
+			name: "invalid condition",
+			doc:  "rules:\n  - condition: anything\n",
+			err:  true,
+		},
+		{
+			name: "unknown field",
+			doc:  "rules:\n  - condition: destructive_update\n    group: web\n",
+			err:  true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p, err := ParsePolicy([]byte(c.doc))
+			if c.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var codes []int
+			for _, rule := range p.Rules {
+				codes = append(codes, rule.ExitCode)
+			}
+			if !reflect.DeepEqual(codes, c.codes) {
+				t.Errorf("got exit codes %v, want %v", codes, c.codes)
+			}
+		})
+	}
+}
+
+func TestPolicyExitCode(t *testing.T) {
+	p, err := ParsePolicy([]byte("rules:\n  - condition: destructive_update\n  - condition: count_decrease\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code := p.ExitCode(nil); code != 0 {
+		t.Errorf("got exit code %d without violations, want 0", code)
+	}
+
+	// the first rule defined wins, whatever the order of the violations
+	violations := []*PolicyViolation{{Rule: p.Rules[1]}, {Rule: p.Rules[0]}}
+	if code := p.ExitCode(violations); code != 2 {
+		t.Errorf("got exit code %d, want 2", code)
+	}
+}
+
+func TestPolicyRuleCheck(t *testing.T) {
+	resp := &api.JobPlanResponse{
+		Annotations: &api.PlanAnnotations{
+			DesiredTGUpdates: map[string]*api.DesiredUpdates{
+				"web":    {DestructiveUpdate: 2},
+				"worker": {DestructiveUpdate: 1},
+				"db":     {InPlaceUpdate: 1},
+			},
+		},
+		FailedTGAllocs: map[string]*api.AllocationMetric{
+			"worker": {CoalescedFailures: 2},
+		},
+		Diff: &api.JobDiff{
+			TaskGroups: []*api.TaskGroupDiff{
+				{
+					Name:   "web",
+					Fields: []*api.FieldDiff{{Name: "Count", Type: "Edited", Old: "3", New: "2"}},
+					Tasks: []*api.TaskDiff{{
+						Name: "nginx",
+						Objects: []*api.ObjectDiff{{
+							Name:   "Config",
+							Type:   "Edited",
+							Fields: []*api.FieldDiff{{Name: "image", Type: "Edited", Old: "nginx:1.14", New: "nginx:1.15"}},
+						}},
+					}},
+				},
+				{
+					Name:   "worker",
+					Fields: []*api.FieldDiff{{Name: "Count", Type: "Edited", Old: "1", New: "4"}},
+					Tasks: []*api.TaskDiff{{
+						Name:   "worker",
+						Fields: []*api.FieldDiff{{Name: "Meta[version]", Type: "Edited", Old: "1", New: "2"}},
+						Objects: []*api.ObjectDiff{{
+							Name:   "Config",
+							Type:   "Edited",
+							Fields: []*api.FieldDiff{{Name: "image", Type: "Edited", Old: "worker:1", New: "worker:2"}},
+						}},
+					}},
+				},
+			},
+		},
+	}
+
+	cases := []struct {
+		name   string
+		rule   *PolicyRule
+		groups []string
+	}{
+		{"destructive update", &PolicyRule{Condition: PolicyDestructiveUpdate}, []string{"web", "worker"}},
+		{"destructive update of groups", &PolicyRule{Condition: PolicyDestructiveUpdate, Groups: []string{"worker", "db"}}, []string{"worker"}},
+		{"placement failure", &PolicyRule{Condition: PolicyPlacementFailure}, []string{"worker"}},
+		{"placement failure of other groups", &PolicyRule{Condition: PolicyPlacementFailure, Groups: []string{"web"}}, nil},
+		{"count decrease", &PolicyRule{Condition: PolicyCountDecrease}, []string{"web"}},
+		{"image change without meta", &PolicyRule{Condition: PolicyImageChangeWithoutMeta}, []string{"web"}},
+		{"image change without meta of other groups", &PolicyRule{Condition: PolicyImageChangeWithoutMeta, Groups: []string{"worker"}}, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var groups []string
+			for _, v := range c.rule.check(resp) {
+				if v.Rule != c.rule {
+					t.Errorf("violation of group \"%s\" has the wrong rule", v.Group)
+				}
+				groups = append(groups, v.Group)
+			}
+			if !reflect.DeepEqual(groups, c.groups) {
+				t.Errorf("got violations of groups %v, want %v", groups, c.groups)
+			}
+		})
+	}
+}
+
+func TestPolicyRuleCheckJobMeta(t *testing.T) {
+	// a job meta change covers image changes in every group
+	resp := &api.JobPlanResponse{
+		Diff: &api.JobDiff{
+			Fields: []*api.FieldDiff{{Name: "Meta[release]", Type: "Added", New: "2"}},
+			TaskGroups: []*api.TaskGroupDiff{{
+				Name: "web",
+				Tasks: []*api.TaskDiff{{
+					Name: "nginx",
+					Objects: []*api.ObjectDiff{{
+						Name:   "Config",
+						Type:   "Edited",
+						Fields: []*api.FieldDiff{{Name: "image", Type: "Edited", Old: "nginx:1.14", New: "nginx:1.15"}},
+					}},
+				}},
+			}},
+		},
+	}
+
+	rule := &PolicyRule{Condition: PolicyImageChangeWithoutMeta}
+	if violations := rule.check(resp); len(violations) != 0 {
+		t.Errorf("got %d violations, want none", len(violations))
+	}
+}
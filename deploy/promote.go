@@ -0,0 +1,175 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+// stagedPromotion tracks the promotion of a deployment's task groups in
+// the configured order
+type stagedPromotion struct {
+	waves    [][]string             // the groups promoted in each wave, in order
+	wave     int                    // the index of the wave being promoted
+	promoted map[string]bool        // the groups promoted, or that need no promotion
+	soaks    map[string]*canarySoak // the soaks of groups whose canaries are healthy, if soaking
+	waveDone time.Time              // when the previous wave was promoted
+}
+
+// ParsePromoteOrder parses the order in which task groups are promoted.
+// Each entry is a wave of one or more groups joined with "+", and entries
+// may also be separated by commas.
+func ParsePromoteOrder(entries []string) ([][]string, error) {
+	var waves [][]string
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		for _, w := range strings.Split(entry, ",") {
+			var wave []string
+			for _, group := range strings.Split(w, "+") {
+				group = strings.TrimSpace(group)
+				if group == "" {
+					continue
+				}
+				if seen[group] {
+					return nil, fmt.Errorf("group \"%s\" listed more than once in promote order", group)
+				}
+				seen[group] = true
+				wave = append(wave, group)
+			}
+			if len(wave) > 0 {
+				waves = append(waves, wave)
+			}
+		}
+	}
+	return waves, nil
+}
+
+// newStagedPromotion returns the staged promotion of a deployment's groups.
+// Groups with canaries that are not in the promote order are promoted in a
+// final wave.
+func (d *Deployment) newStagedPromotion(dep *api.Deployment) (*stagedPromotion, error) {
+	p := &stagedPromotion{
+		promoted: make(map[string]bool),
+		soaks:    make(map[string]*canarySoak),
+	}
+
+	listed := make(map[string]bool)
+	for _, wave := range d.promoteOrder {
+		for _, group := range wave {
+			if d.job.LookupTaskGroup(group) == nil {
+				return nil, fmt.Errorf("promote order group \"%s\" not found in job \"%s\"", group, *d.job.Name)
+			}
+			listed[group] = true
+		}
+		p.waves = append(p.waves, wave)
+	}
+
+	var rest []string
+	for name, state := range dep.TaskGroups {
+		if !listed[name] && state.DesiredCanaries > 0 {
+			rest = append(rest, name)
+		}
+	}
+	if len(rest) > 0 {
+		sort.Strings(rest)
+		p.waves = append(p.waves, rest)
+	}
+
+	return p, nil
+}
+
+// promoteInOrder promotes the groups of the current wave whose canaries
+// are healthy (and have soaked, been analyzed and been approved, if set),
+// and moves on to the next wave once every group of the wave is promoted
+// and the pause between waves has passed. The deployment is marked
+// promoted once every wave is.
+func (d *Deployment) promoteInOrder(ctx context.Context, dep *api.Deployment) error {
+	if d.staged == nil {
+		p, err := d.newStagedPromotion(dep)
+		if err != nil {
+			return err
+		}
+		d.staged = p
+	}
+	p := d.staged
+
+	for p.wave < len(p.waves) {
+		if p.wave > 0 && time.Since(p.waveDone) < d.promotePause {
+			d.log.Debug("waiting %s before promoting wave %d of deployment \"%s\"",
+				(d.promotePause - time.Since(p.waveDone)).Round(time.Second), p.wave+1, limit(d.deploymentID, d.idLen))
+			return nil
+		}
+
+		var ready []string
+		pending := 0
+		for _, group := range p.waves[p.wave] {
+			if p.promoted[group] {
+				continue
+			}
+
+			// groups not being updated, or without canaries, need no promotion
+			state, ok := dep.TaskGroups[group]
+			if !ok || state.DesiredCanaries == 0 || state.Promoted {
+				p.promoted[group] = true
+				continue
+			}
+
+			if state.HealthyAllocs < state.DesiredCanaries {
+				d.log.Debug("group \"%s\": %d of %d canaries healthy", group, state.HealthyAllocs, state.DesiredCanaries)
+				pending++
+				continue
+			}
+
+			if d.canarySoak > 0 {
+				s := p.soaks[group]
+				if s == nil {
+					var err error
+					if p.soaks[group], err = d.startCanarySoak(dep, []string{group}); err != nil {
+						return err
+					}
+					pending++
+					continue
+				}
+
+				done, reason, err := d.checkCanarySoak(s)
+				switch {
+				case err != nil:
+					return err
+				case reason != "":
+					return d.abortPromotion(dep, "canary soak failed: "+reason)
+				case !done:
+					pending++
+					continue
+				}
+			}
+
+			ready = append(ready, group)
+		}
+
+		if len(ready) > 0 {
+			promoted, err := d.promoteCanaries(ctx, dep, ready)
+			if err != nil || !promoted {
+				return err
+			}
+			for _, group := range ready {
+				p.promoted[group] = true
+			}
+		}
+		if pending > 0 {
+			return nil
+		}
+
+		if len(p.waves) > 1 {
+			d.log.Info("wave %d of %d of deployment \"%s\" promoted", p.wave+1, len(p.waves), limit(d.deploymentID, d.idLen))
+		}
+		p.wave++
+		p.waveDone = time.Now()
+	}
+
+	d.promoted = true
+	return nil
+}
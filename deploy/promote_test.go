@@ -0,0 +1,42 @@
+package deploy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePromoteOrder(t *testing.T) {
+	cases := []struct {
+		name    string
+		entries []string
+		want    [][]string
+		err     bool
+	}{
+		{"empty", nil, nil, false},
+		{"single group", []string{"api"}, [][]string{{"api"}}, false},
+		{"comma separated", []string{"api,worker"}, [][]string{{"api"}, {"worker"}}, false},
+		{"slice entries", []string{"api", "worker"}, [][]string{{"api"}, {"worker"}}, false},
+		{"joined groups", []string{"api+web, worker"}, [][]string{{"api", "web"}, {"worker"}}, false},
+		{"blank groups", []string{" api + ,,worker"}, [][]string{{"api"}, {"worker"}}, false},
+		{"repeated group", []string{"api,worker+api"}, nil, true},
+		{"repeated across entries", []string{"api", "api"}, nil, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := ParsePromoteOrder(c.entries)
+			if c.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package deploy
+
+import (
+	"path"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// RecordsKey is the key under a prefix where nomadctl keeps its own
+// records, such as which jobs were deployed from the prefix's job keys.
+// It is never a job key.
+const RecordsKey = ".nomadctl"
+
+// DeployedJobsKey returns the key under which the jobs deployed from the
+// job keys of a prefix are recorded, each as "${JOB_ID}" with the full job
+// key it was deployed from as its value
+func DeployedJobsKey(prefix string) string {
+	return path.Join(prefix, RecordsKey, "jobs")
+}
+
+// recordJobKey records the job key the job was deployed from under the
+// job key's prefix, so that jobs deployed by nomadctl can be told apart
+// without changing the job itself. Failing to record it is only logged.
+func (d *Deployment) recordJobKey() {
+	if d.jobKey == "" || d.consul == nil {
+		return
+	}
+
+	key := path.Join(DeployedJobsKey(path.Dir(d.jobKey)), *d.job.ID)
+	if _, err := d.consul.KV().Put(&consul.KVPair{Key: key, Value: []byte(d.jobKey)}, nil); err != nil {
+		d.log.Warning("failed to record job \"%s\" as deployed from \"%s\": %v", *d.job.ID, d.jobKey, err)
+	}
+}
@@ -0,0 +1,193 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/nomad/nomad/structs"
+	"github.com/pkg/errors"
+)
+
+// FailureAction is the action taken when a job fails to deploy
+type FailureAction string
+
+const (
+	// FailureNone leaves the failed job in place
+	FailureNone FailureAction = "none"
+
+	// FailureRevert reverts the job to its last stable version, or the
+	// version it replaced if no version is stable
+	FailureRevert FailureAction = "revert"
+
+	// FailureRevertAndWait reverts the job like FailureRevert, then
+	// monitors the job until the revert is deployed
+	FailureRevertAndWait FailureAction = "revert-and-wait"
+)
+
+// FailureActions lists the valid failure actions
+var FailureActions = []FailureAction{FailureNone, FailureRevert, FailureRevertAndWait}
+
+// ParseFailureAction returns the FailureAction matching the given string
+func ParseFailureAction(s string) (FailureAction, error) {
+	for _, a := range FailureActions {
+		if string(a) == s {
+			return a, nil
+		}
+	}
+	return "", fmt.Errorf("invalid failure action \"%s\", must be one of %v", s, FailureActions)
+}
+
+// revertsOnFailure returns whether the job is reverted when it fails to deploy
+func (d *Deployment) revertsOnFailure() bool {
+	return d.onFailure == FailureRevert || d.onFailure == FailureRevertAndWait
+}
+
+// handleFailure takes the deployment's failure action if the registered
+// job failed to deploy, and returns an error reporting both the failure and
+// the outcome of the action. Otherwise, the given error is returned.
+func (d *Deployment) handleFailure(ctx context.Context, err error) error {
+	if !d.failed || !d.revertsOnFailure() || ctx.Err() != nil {
+		return err
+	}
+	d.failed = false
+
+	name := *d.job.Name
+	if err != nil {
+		d.log.Error("%v", err)
+	}
+
+	version, evalID, err := d.revertFailedJob()
+	if err != nil {
+		return errors.Wrapf(err, "deployment of job \"%s\" failed and could not be reverted", name)
+	}
+	if d.onFailure == FailureRevert {
+		return fmt.Errorf("deployment of job \"%s\" failed, reverted to version %d", name, version)
+	}
+
+	ok, err := d.watchRevert(ctx, evalID)
+	switch {
+	case err != nil:
+		return errors.Wrapf(err, "deployment of job \"%s\" failed, reverted to version %d but the revert did not complete", name, version)
+	case !ok:
+		return fmt.Errorf("deployment of job \"%s\" failed, reverted to version %d but the revert also failed, manual intervention required", name, version)
+	default:
+		return fmt.Errorf("deployment of job \"%s\" failed, reverted to version %d successfully", name, version)
+	}
+}
+
+// revertFailedJob reverts a job that failed to deploy to its last stable
+// version (or the version it replaced), unless Nomad already reverted it,
+// and returns the version the job was reverted to, along with the
+// evaluation ID of the revert, if any
+func (d *Deployment) revertFailedJob() (uint64, string, error) {
+	if d.failedVersion != nil {
+		job, _, err := d.client.Jobs().Info(*d.job.ID, nil)
+		if err != nil {
+			return 0, "", errors.Wrap(err, "failed to get job info")
+		}
+		// nomad reverts the job itself if the update stanza has auto_revert set
+		if *job.Version != *d.failedVersion {
+			d.log.Info("job \"%s\" already reverted to version %d", *d.job.Name, *job.Version)
+			return *job.Version, "", nil
+		}
+	}
+	return d.revertToLastStable()
+}
+
+// revertToLastStable reverts the job to the most recent version marked
+// stable by Nomad, excluding the current version, and returns that
+// version along with the evaluation ID of the revert. Versions of system
+// jobs, and of jobs without deployments, are never marked stable, so if no
+// version is stable the job is reverted to the version it replaced.
+func (d *Deployment) revertToLastStable() (uint64, string, error) {
+	name := *d.job.Name
+
+	versions, _, _, err := d.client.Jobs().Versions(name, false, nil)
+	if err != nil {
+		return 0, "", errors.Wrap(err, "failed to get job versions")
+	}
+	if len(versions) == 0 {
+		return 0, "", fmt.Errorf("no versions found for job \"%s\"", name)
+	}
+
+	// versions are returned newest first
+	current := *versions[0].Version
+
+	var target *uint64
+	for _, v := range versions[1:] {
+		if v.Stable != nil && *v.Stable {
+			target = v.Version
+			d.log.Info("reverting job \"%s\" from version %d to stable version %d", name, current, *target)
+			break
+		}
+	}
+	if target == nil {
+		if d.previousVersion == nil || *d.previousVersion == current {
+			return 0, "", fmt.Errorf("no stable or previous version of job \"%s\" found to revert to", name)
+		}
+		target = d.previousVersion
+		d.log.Info("no stable version of job \"%s\", reverting from version %d to previous version %d", name, current, *target)
+	}
+
+	resp, _, err := d.client.Jobs().Revert(name, *target, &current, nil)
+	if err != nil {
+		return 0, "", errors.Wrap(err, "job revert failed")
+	}
+	if resp.EvalID != "" {
+		d.log.Info("job \"%s\" reverted, evaluation \"%s\"", name, limit(resp.EvalID, d.idLen))
+	}
+	return *target, resp.EvalID, nil
+}
+
+// watchRevert monitors a job reverted after failing to deploy, and returns
+// whether the revert was deployed successfully. A failed revert is not
+// itself reverted.
+func (d *Deployment) watchRevert(ctx context.Context, evalID string) (bool, error) {
+	d.onFailure = FailureNone
+	d.autoRevert = false
+	d.promoted = false
+	d.needsPromotion = false
+	d.soak = nil
+	d.staged = nil
+	d.approvalKey = "" // reverting to a stable version needs no approval
+	d.deploymentID = ""
+	if d.timeout > 0 {
+		d.deadline = time.Now().Add(d.timeout)
+	}
+
+	if evalID != "" {
+		if ok, err := d.monitorEvalStatus(ctx, evalID); err != nil || !ok {
+			return false, err
+		}
+		eval, _, err := d.client.Evaluations().Info(evalID, nil)
+		if err != nil {
+			return false, err
+		}
+		d.deploymentID = eval.DeploymentID
+	} else if *d.job.Type == structs.JobTypeService {
+		// the job was reverted by nomad, so watch its latest deployment
+		dep, _, err := d.client.Jobs().LatestDeployment(*d.job.ID, nil)
+		if err != nil {
+			return false, errors.Wrap(err, "failed to get latest deployment")
+		}
+		if dep != nil && (d.failedVersion == nil || dep.JobVersion != *d.failedVersion) {
+			d.deploymentID = dep.ID
+		}
+	}
+
+	switch *d.job.Type {
+	case structs.JobTypeService:
+		if d.deploymentID != "" {
+			d.log.Info("monitoring revert deployment \"%s\"", limit(d.deploymentID, d.idLen))
+			return d.monitorDeployment(ctx)
+		}
+		return d.waitJobRunning(ctx)
+
+	case structs.JobTypeBatch:
+		return d.waitJobRunning(ctx)
+
+	default:
+		return true, nil
+	}
+}
@@ -0,0 +1,142 @@
+package deploy
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/nomad/api"
+	"github.com/pkg/errors"
+)
+
+// RollbackInput represents the input for a rollback
+type RollbackInput struct {
+	JobName        string              // the name of the job to roll back
+	Version        *uint64             // the version to roll back to, defaults to the previous version
+	LastStable     bool                // whether to roll back to the last stable version
+	AutoPromote    bool                // whether a canary deployment should be automatically promoted
+	CanarySoak     time.Duration       // how long healthy canaries soak before being automatically promoted
+	ConsulClient   *consul.Client      // checks canary services during a soak, if set
+	PromoteOrder   [][]string          // the waves of groups automatically promoted in turn, see ParsePromoteOrder
+	PromotePause   time.Duration       // how long to wait between promoting waves of groups
+	CanaryAnalysis *AnalysisThresholds // compares canaries against stable allocations before auto-promotion, if set
+	Timeout        time.Duration       // how long to wait for the deployment to complete, zero to wait forever
+	AutoRevert     bool                // whether a timed out job should be reverted to its last stable version
+	OnFailure      FailureAction       // the action taken when the rollback fails to deploy, FailureNone if empty
+	Verbose        bool                // whether long UUIDs should be logged
+	Client         *api.Client         // the Nomad API client, one is created from the environment if nil
+}
+
+// NewRollback generates a deployment that reverts an existing remote
+// job to one of its previous versions
+func NewRollback(i *RollbackInput) (*Deployment, error) {
+	if i.Version != nil && i.LastStable {
+		return nil, fmt.Errorf("cannot specify Version and LastStable")
+	}
+
+	var err error
+	client := i.Client
+	if client == nil {
+		if client, err = api.NewClient(api.DefaultConfig()); err != nil {
+			return nil, err
+		}
+	}
+
+	versions, _, _, err := client.Jobs().Versions(i.JobName, false, nil)
+	if err != nil {
+		if strings.Contains(err.Error(), "404") {
+			return nil, fmt.Errorf("job \"%s\" not found on server", i.JobName)
+		}
+		return nil, errors.Wrap(err, "failed to get job versions")
+	}
+	if len(versions) < 2 {
+		return nil, fmt.Errorf("job \"%s\" has no previous versions", i.JobName)
+	}
+
+	// versions are returned newest first
+	current := versions[0]
+	var target *api.Job
+
+	switch {
+	case i.Version != nil:
+		for _, v := range versions {
+			if *v.Version == *i.Version {
+				target = v
+				break
+			}
+		}
+		if target == nil {
+			return nil, fmt.Errorf("version %d of job \"%s\" not found", *i.Version, i.JobName)
+		}
+		if target == current {
+			return nil, fmt.Errorf("version %d is the current version of job \"%s\"", *i.Version, i.JobName)
+		}
+	case i.LastStable:
+		for _, v := range versions[1:] {
+			if v.Stable != nil && *v.Stable {
+				target = v
+				break
+			}
+		}
+		if target == nil {
+			return nil, fmt.Errorf("no stable version of job \"%s\" found to roll back to", i.JobName)
+		}
+	default:
+		target = versions[1]
+	}
+
+	d := &Deployment{
+		client:        client,
+		job:           target,
+		autoPromote:   i.AutoPromote,
+		canarySoak:    i.CanarySoak,
+		consul:        i.ConsulClient,
+		promoteOrder:  i.PromoteOrder,
+		promotePause:  i.PromotePause,
+		analysis:      i.CanaryAnalysis,
+		timeout:       i.Timeout,
+		autoRevert:    i.AutoRevert,
+		onFailure:     i.OnFailure,
+		revertVersion: target.Version,
+		priorVersion:  *current.Version,
+		versions:      versions,
+	}
+	d.setIDLength(i.Verbose)
+
+	return d, nil
+}
+
+// PrintVersions writes a table of the remote job's versions to standard
+// out, marking the current and target versions of a rollback
+func (d *Deployment) PrintVersions() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Version\tStable\tSubmit Date\t")
+
+	for _, v := range d.versions {
+		var submitted string
+		if v.SubmitTime != nil {
+			submitted = formatTime(time.Unix(0, *v.SubmitTime))
+		}
+
+		var note string
+		switch {
+		case *v.Version == d.priorVersion:
+			note = "(current)"
+		case d.revertVersion != nil && *v.Version == *d.revertVersion:
+			note = "(rollback target)"
+		}
+
+		fmt.Fprintf(w, "%d\t%t\t%s\t%s\n", *v.Version, v.Stable != nil && *v.Stable, submitted, note)
+	}
+
+	w.Flush()
+	fmt.Println()
+}
+
+// isRollback returns true if the deployment reverts the job to a previous version
+func (d *Deployment) isRollback() bool {
+	return d.revertVersion != nil
+}
@@ -86,3 +86,63 @@ func IsFatal() bool {
 func IsPanic() bool {
 	return log.GetLevel() == log.PanicLevel
 }
+
+// Logger logs messages with an optional prefix, such as the name of the
+// job a message relates to. A nil Logger logs without a prefix.
+type Logger struct {
+	prefix string
+	hook   func(level, message string)
+}
+
+// NewLogger returns a Logger that prefixes messages with "[prefix] "
+func NewLogger(prefix string) *Logger {
+	return &Logger{prefix: prefix}
+}
+
+// NewLoggerWithHook returns a Logger that also passes each message it
+// logs, without its prefix, to hook along with the message's level
+func NewLoggerWithHook(prefix string, hook func(level, message string)) *Logger {
+	return &Logger{prefix: prefix, hook: hook}
+}
+
+// Debug logs a message with severity DEBUG.
+func (l *Logger) Debug(format string, v ...interface{}) {
+	Debug(l.format(format), v...)
+	if IsDebug() {
+		l.notify("debug", format, v...)
+	}
+}
+
+// Info logs a message with severity INFO.
+func (l *Logger) Info(format string, v ...interface{}) {
+	Info(l.format(format), v...)
+	l.notify("info", format, v...)
+}
+
+// Warning logs a message with severity WARNING.
+func (l *Logger) Warning(format string, v ...interface{}) {
+	Warning(l.format(format), v...)
+	l.notify("warning", format, v...)
+}
+
+// Error logs a message with severity ERROR.
+func (l *Logger) Error(format string, v ...interface{}) {
+	Error(l.format(format), v...)
+	l.notify("error", format, v...)
+}
+
+// notify passes a message to the logger's hook, if any
+func (l *Logger) notify(level, format string, v ...interface{}) {
+	if l == nil || l.hook == nil {
+		return
+	}
+	l.hook(level, fmt.Sprintf(format, v...))
+}
+
+// format adds the logger's prefix to a format string
+func (l *Logger) format(format string) string {
+	if l == nil || l.prefix == "" {
+		return format
+	}
+	return fmt.Sprintf("[%s] %s", strings.Replace(l.prefix, "%", "%%", -1), format)
+}
@@ -1,17 +1,25 @@
 package nomad
 
 import (
+	"context"
 	"fmt"
 )
 
-// RestartJob restarts a nomad job by deregistering/registering
-func (n *Client) RestartJob(jobName string) error {
+// RestartJob restarts a nomad job by deregistering/registering. Once the
+// job is stopped it is always started again, even if ctx is cancelled.
+func (n *Client) RestartJob(ctx context.Context, jobName string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	job, _, err := n.Jobs().Info(jobName, nil)
 	if err != nil {
 		return err
 	}
 
 	// stop
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if _, _, err = n.Jobs().Deregister(jobName, false, nil); err != nil {
 		return err
 	}
@@ -25,9 +33,13 @@ func (n *Client) RestartJob(jobName string) error {
 	return nil
 }
 
-// RestartTaskGroup restarts a Nomad task group by
-// temporarily setting the count to zero
-func (n *Client) RestartTaskGroup(jobName string, groupName string) error {
+// RestartTaskGroup restarts a Nomad task group by temporarily setting the
+// count to zero. Once the count is zero it is always set back, even if ctx
+// is cancelled.
+func (n *Client) RestartTaskGroup(ctx context.Context, jobName string, groupName string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	job, _, err := n.Jobs().Info(jobName, nil)
 	if err != nil {
 		return err
@@ -38,6 +50,9 @@ func (n *Client) RestartTaskGroup(jobName string, groupName string) error {
 			prevCount := tg.Count
 
 			//set to zero
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			tg.Count = intToPtr(0)
 			if _, _, err := n.Jobs().Register(job, nil); err != nil {
 				return err
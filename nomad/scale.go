@@ -1,6 +1,7 @@
 package nomad
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/bdclark/nomadctl/logging"
@@ -8,7 +9,7 @@ import (
 )
 
 // AdjustTaskGroupCount raises/lowers the count of a task group
-func (n *Client) AdjustTaskGroupCount(job *api.Job, groupName string, delta int) error {
+func (n *Client) AdjustTaskGroupCount(ctx context.Context, job *api.Job, groupName string, delta int) error {
 	for _, tg := range job.TaskGroups {
 		if *tg.Name == groupName {
 			newCount := intToPtr(ptrToInt(tg.Count) + delta)
@@ -16,6 +17,9 @@ func (n *Client) AdjustTaskGroupCount(job *api.Job, groupName string, delta int)
 				return fmt.Errorf("Count cannot be less than zero")
 			}
 			logging.Info("scaling group \"%s\" of job \"%s\" from %d to %d", groupName, *job.Name, *tg.Count, *newCount)
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			tg.Count = newCount
 			if _, _, err := n.Jobs().Register(job, nil); err != nil {
 				return err
@@ -27,7 +31,7 @@ func (n *Client) AdjustTaskGroupCount(job *api.Job, groupName string, delta int)
 }
 
 // SetTaskGroupCount sets the count of a task group to the given count
-func (n *Client) SetTaskGroupCount(job *api.Job, groupName string, count int) error {
+func (n *Client) SetTaskGroupCount(ctx context.Context, job *api.Job, groupName string, count int) error {
 	for _, tg := range job.TaskGroups {
 		if *tg.Name == groupName {
 			newCount := intToPtr(count)
@@ -35,6 +39,9 @@ func (n *Client) SetTaskGroupCount(job *api.Job, groupName string, count int) er
 				return nil // nothing to do
 			}
 			logging.Info("scaling group \"%s\" of job \"%s\" from %d to %d", groupName, *job.Name, *tg.Count, *newCount)
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			tg.Count = newCount
 			if _, _, err := n.Jobs().Register(job, nil); err != nil {
 				return err
@@ -0,0 +1,115 @@
+package ops
+
+import (
+	"context"
+	"time"
+
+	"github.com/bdclark/nomadctl/deploy"
+	"github.com/bdclark/nomadctl/logging"
+	consul "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/nomad/api"
+)
+
+// DeployOptions are the options for deploying a job
+type DeployOptions struct {
+	Jobspec          []byte                     // the rendered job to deploy
+	JobKey           string                     // the full Consul job key the job is rendered from, recorded in Consul once registered, if any
+	UseTemplateCount bool                       // whether to deploy the template's group counts rather than the remote job's
+	AutoPromote      bool                       // whether to promote canaries once healthy
+	CanarySoak       time.Duration              // how long healthy canaries soak before being promoted
+	ConsulClient     *consul.Client             // checks canary services during a soak, if set
+	PromoteOrder     [][]string                 // the waves of groups promoted in turn, see deploy.ParsePromoteOrder
+	PromotePause     time.Duration              // how long to wait between promoting waves of groups
+	CanaryAnalysis   *deploy.AnalysisThresholds // compares canaries against stable allocations before promotion, if set
+	ApprovalKey      string                     // the Consul key under which canaries await approval before promotion, if required
+	AutoRevert       bool                       // whether to revert the job if the deployment times out
+	OnFailure        deploy.FailureAction       // the action taken when the job fails to deploy, deploy.FailureNone if empty
+	Timeout          time.Duration              // how long to wait for the deployment to complete, zero to wait forever
+	Detach           bool                       // whether to return once the job is registered rather than monitor it
+	EnforceIndex     bool                       // whether to only register the job if the remote job's modify index is JobModifyIndex
+	JobModifyIndex   uint64                     // the modify index to enforce, such as that of a plan
+	Logger           *logging.Logger            // logs the deployment, if set
+}
+
+// DeployResult is the result of deploying a job
+type DeployResult struct {
+	Success    bool               // whether the deployment completed successfully
+	Deployment *deploy.Deployment // the deployment, such as for its IDs
+}
+
+// Deploy deploys a job. If the modify index is enforced and the remote job
+// has changed, deploy.ErrJobModified is returned. If the context is cancelled while the deployment is being
+// monitored, the action set with deploy.WithInterrupt is taken.
+func Deploy(ctx context.Context, client *api.Client, o *DeployOptions) (*DeployResult, error) {
+	d, err := deploy.NewDeployment(&deploy.NewDeploymentInput{
+		Client:           client,
+		Jobspec:          &o.Jobspec,
+		JobKey:           o.JobKey,
+		UseTemplateCount: o.UseTemplateCount,
+		AutoPromote:      o.AutoPromote,
+		CanarySoak:       o.CanarySoak,
+		ConsulClient:     o.ConsulClient,
+		PromoteOrder:     o.PromoteOrder,
+		PromotePause:     o.PromotePause,
+		CanaryAnalysis:   o.CanaryAnalysis,
+		ApprovalKey:      o.ApprovalKey,
+		AutoRevert:       o.AutoRevert,
+		OnFailure:        o.OnFailure,
+		Timeout:          o.Timeout,
+		Detach:           o.Detach,
+		EnforceIndex:     o.EnforceIndex,
+		JobModifyIndex:   o.JobModifyIndex,
+		Logger:           o.Logger,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r := &DeployResult{Deployment: d}
+	r.Success, err = d.Deploy(ctx)
+	return r, err
+}
+
+// RedeployOptions are the options for redeploying a job
+type RedeployOptions struct {
+	Job            string                     // the name of the job
+	Groups         []string                   // the task groups to redeploy, every group if empty
+	AutoPromote    bool                       // whether to promote canaries once healthy
+	CanarySoak     time.Duration              // how long healthy canaries soak before being promoted
+	ConsulClient   *consul.Client             // checks canary services during a soak, if set
+	PromoteOrder   [][]string                 // the waves of groups promoted in turn, see deploy.ParsePromoteOrder
+	PromotePause   time.Duration              // how long to wait between promoting waves of groups
+	CanaryAnalysis *deploy.AnalysisThresholds // compares canaries against stable allocations before promotion, if set
+	AutoRevert     bool                       // whether to revert the job if the deployment times out
+	OnFailure      deploy.FailureAction       // the action taken when the job fails to deploy, deploy.FailureNone if empty
+	Timeout        time.Duration              // how long to wait for the deployment to complete, zero to wait forever
+	Detach         bool                       // whether to return once the job is registered rather than monitor it
+	Logger         *logging.Logger            // logs the deployment, if set
+}
+
+// Redeploy redeploys an existing job, causing a rolling restart
+func Redeploy(ctx context.Context, client *api.Client, o *RedeployOptions) (*DeployResult, error) {
+	d, err := deploy.NewRedeployment(&deploy.RedeploymentInput{
+		Client:         client,
+		JobName:        o.Job,
+		TaskGroupNames: o.Groups,
+		AutoPromote:    o.AutoPromote,
+		CanarySoak:     o.CanarySoak,
+		ConsulClient:   o.ConsulClient,
+		PromoteOrder:   o.PromoteOrder,
+		PromotePause:   o.PromotePause,
+		CanaryAnalysis: o.CanaryAnalysis,
+		AutoRevert:     o.AutoRevert,
+		OnFailure:      o.OnFailure,
+		Timeout:        o.Timeout,
+		Detach:         o.Detach,
+		Logger:         o.Logger,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r := &DeployResult{Deployment: d}
+	r.Success, err = d.Deploy(ctx)
+	return r, err
+}
@@ -0,0 +1,29 @@
+// Package ops implements the operations of nomadctl, such as rendering,
+// planning, deploying and scaling jobs, for use as a library. Operations
+// are configured with typed options rather than global config, use the
+// Nomad and Consul clients they are given, and return their results and
+// errors rather than exiting. A nil client is created from the
+// environment, the same as the Nomad and Consul CLIs.
+package ops
+
+import (
+	"github.com/bdclark/nomadctl/nomad"
+	consul "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/nomad/api"
+)
+
+// nomadClient returns the given Nomad client, or one created from the environment
+func nomadClient(client *api.Client) (*nomad.Client, error) {
+	if client == nil {
+		return nomad.NewNomadClient(nil)
+	}
+	return &nomad.Client{Client: client}, nil
+}
+
+// consulClient returns the given Consul client, or one created from the environment
+func consulClient(client *consul.Client) (*consul.Client, error) {
+	if client == nil {
+		return consul.NewClient(consul.DefaultConfig())
+	}
+	return client, nil
+}
@@ -0,0 +1,138 @@
+package ops
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/nomad/api"
+)
+
+// nomadStub is an httptest Nomad agent serving the job endpoints for a
+// single job, recording the versions of the job registered with it
+type nomadStub struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	job          *api.Job
+	registered   []*api.Job
+	deregistered bool
+	requests     int
+}
+
+// newNomadStub starts a Nomad stub serving the given job, to be closed
+// by the caller
+func newNomadStub(job *api.Job) *nomadStub {
+	s := &nomadStub{job: job}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func (s *nomadStub) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+
+	w.Header().Set("X-Nomad-Index", "1")
+	w.Header().Set("X-Nomad-LastContact", "0")
+	w.Header().Set("X-Nomad-KnownLeader", "true")
+
+	switch {
+	case r.URL.Path == "/v1/job/"+*s.job.ID && r.Method == "GET":
+		json.NewEncoder(w).Encode(s.job)
+	case r.URL.Path == "/v1/job/"+*s.job.ID && r.Method == "DELETE":
+		s.deregistered = true
+		json.NewEncoder(w).Encode(&api.JobDeregisterResponse{EvalID: "eval"})
+	case r.URL.Path == "/v1/jobs" && (r.Method == "PUT" || r.Method == "POST"):
+		var req api.JobRegisterRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.registered = append(s.registered, req.Job)
+		json.NewEncoder(w).Encode(&api.JobRegisterResponse{EvalID: "eval"})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// client returns a Nomad client of the stub
+func (s *nomadStub) client(t *testing.T) *api.Client {
+	config := api.DefaultConfig()
+	config.Address = s.URL
+	client, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// groupCounts returns the counts of the task groups of each registered job
+func (s *nomadStub) groupCounts(group string) []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var counts []int
+	for _, job := range s.registered {
+		for _, tg := range job.TaskGroups {
+			if *tg.Name == group {
+				counts = append(counts, *tg.Count)
+			}
+		}
+	}
+	return counts
+}
+
+// testJob returns a job with a single "web" task group of the given count
+func testJob(count int) *api.Job {
+	id, group := "example", "web"
+	return &api.Job{
+		ID:         &id,
+		Name:       &id,
+		TaskGroups: []*api.TaskGroup{{Name: &group, Count: &count}},
+	}
+}
+
+// newConsulStub starts an httptest Consul agent serving recursive KV
+// reads of the given pairs, to be closed by the caller
+func newConsulStub(pairs consul.KVPairs) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Consul-Index", "1")
+		w.Header().Set("X-Consul-LastContact", "0")
+		w.Header().Set("X-Consul-KnownLeader", "true")
+
+		if !strings.HasPrefix(r.URL.Path, "/v1/kv/") {
+			http.NotFound(w, r)
+			return
+		}
+		prefix := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+
+		var matched consul.KVPairs
+		for _, pair := range pairs {
+			if strings.HasPrefix(pair.Key, prefix) {
+				matched = append(matched, pair)
+			}
+		}
+		if len(matched) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(matched)
+	}))
+}
+
+// consulStubClient returns a Consul client of a Consul stub
+func consulStubClient(t *testing.T, s *httptest.Server) *consul.Client {
+	config := consul.DefaultConfig()
+	config.Address = strings.TrimPrefix(s.URL, "http://")
+	config.Scheme = "http"
+	client, err := consul.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
@@ -0,0 +1,56 @@
+package ops
+
+import (
+	"context"
+
+	"github.com/bdclark/nomadctl/deploy"
+	"github.com/hashicorp/nomad/api"
+)
+
+// PlanOptions are the options for planning a job
+type PlanOptions struct {
+	Jobspec          []byte            // the rendered job to plan
+	UseTemplateCount bool              // whether to plan with the template's group counts rather than the remote job's
+	Output           *deploy.PlanInput // how the plan is written, nothing is written if nil
+	Policy           *deploy.Policy    // a policy to check the plan against, if set
+}
+
+// PlanResult is the result of planning a job
+type PlanResult struct {
+	Changes    bool                      // whether allocations will be created or destroyed
+	Violations []*deploy.PolicyViolation // the policy rules the plan violates
+	Deployment *deploy.Deployment        // the planned deployment, such as for saving a plan file
+}
+
+// Plan plans a job exactly as Deploy would register it
+func Plan(ctx context.Context, client *api.Client, o *PlanOptions) (*PlanResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	d, err := deploy.NewDeployment(&deploy.NewDeploymentInput{
+		Client:           client,
+		Jobspec:          &o.Jobspec,
+		UseTemplateCount: o.UseTemplateCount,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	output := o.Output
+	if output == nil {
+		output = &deploy.PlanInput{Quiet: true}
+	}
+
+	r := &PlanResult{Deployment: d}
+	if r.Changes, err = d.Plan(output); err != nil {
+		return nil, err
+	}
+
+	if o.Policy != nil {
+		if r.Violations, err = d.CheckPolicy(o.Policy); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,89 @@
+package ops
+
+import (
+	"context"
+	"strings"
+
+	"github.com/bdclark/nomadctl/template"
+	consul "github.com/hashicorp/consul/api"
+)
+
+// RenderOptions are the options for rendering a job template
+type RenderOptions struct {
+	Source        string            // path or URL of the template, mutually exclusive of Contents
+	Contents      string            // contents of the template
+	LeftDelim     string            // left template delimiter, "{{" if empty
+	RightDelim    string            // right template delimiter, "}}" if empty
+	ErrMissingKey bool              // whether to error when a map key is missing
+	Options       map[string]string // go-getter options if Source is a URL
+}
+
+// Render renders a job template using Consul-Template. Template functions
+// connect to Consul with the given client config, or the environment if
+// nil; it takes a config rather than a client as Consul-Template creates
+// its own clients.
+func Render(ctx context.Context, config *consul.Config, o *RenderOptions) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	t, err := template.NewTemplate(&template.NewTemplateInput{
+		Source:        o.Source,
+		Contents:      o.Contents,
+		LeftDelim:     o.LeftDelim,
+		RightDelim:    o.RightDelim,
+		ErrMissingKey: o.ErrMissingKey,
+		Options:       o.Options,
+		Consul:        templateConsulConfig(config),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t.Render(ctx)
+}
+
+// templateConsulConfig returns the template's Consul connection for a
+// Consul client config, or nil to use the environment
+func templateConsulConfig(config *consul.Config) *template.ConsulConfig {
+	if config == nil {
+		return nil
+	}
+
+	c := &template.ConsulConfig{
+		Address:       config.Address,
+		Token:         config.Token,
+		CACert:        config.TLSConfig.CAFile,
+		CAPath:        config.TLSConfig.CAPath,
+		ClientCert:    config.TLSConfig.CertFile,
+		ClientKey:     config.TLSConfig.KeyFile,
+		TLSServerName: config.TLSConfig.Address,
+		TLSSkipVerify: config.TLSConfig.InsecureSkipVerify,
+	}
+	if config.Scheme != "" && c.Address != "" {
+		c.Address = config.Scheme + "://" + c.Address
+	}
+	return c
+}
+
+// ReadJobKey returns the non-empty values stored under a Consul job key,
+// by their path relative to the job key, such as "deploy/timeout"
+func ReadJobKey(ctx context.Context, client *consul.Client, jobKey string) (map[string]string, error) {
+	client, err := consulClient(client)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := strings.TrimSuffix(jobKey, "/") + "/"
+	pairs, _, err := client.KV().List(prefix, (&consul.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	for _, pair := range pairs {
+		if len(pair.Value) > 0 {
+			values[pair.Key[len(prefix):]] = string(pair.Value)
+		}
+	}
+	return values, nil
+}
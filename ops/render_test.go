@@ -0,0 +1,94 @@
+package ops
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/bdclark/nomadctl/template"
+	consul "github.com/hashicorp/consul/api"
+)
+
+func TestRender(t *testing.T) {
+	b, err := Render(context.Background(), nil, &RenderOptions{Contents: `job "[[ "example" ]]" {}`, LeftDelim: "[[", RightDelim: "]]"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), `job "example" {}`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRenderCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := Render(ctx, nil, &RenderOptions{Contents: `job "example" {}`}); err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestTemplateConsulConfig(t *testing.T) {
+	if c := templateConsulConfig(nil); c != nil {
+		t.Errorf("got %+v for a nil config, want nil", c)
+	}
+
+	config := &consul.Config{
+		Address: "consul.example.com:8501",
+		Scheme:  "https",
+		Token:   "secret",
+		TLSConfig: consul.TLSConfig{
+			Address:            "consul.example.com",
+			CAFile:             "ca.pem",
+			CertFile:           "cert.pem",
+			KeyFile:            "key.pem",
+			InsecureSkipVerify: true,
+		},
+	}
+	want := &template.ConsulConfig{
+		Address:       "https://consul.example.com:8501",
+		Token:         "secret",
+		CACert:        "ca.pem",
+		ClientCert:    "cert.pem",
+		ClientKey:     "key.pem",
+		TLSServerName: "consul.example.com",
+		TLSSkipVerify: true,
+	}
+	if got := templateConsulConfig(config); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestReadJobKey(t *testing.T) {
+	s := newConsulStub(consul.KVPairs{
+		{Key: "jobs/example/template/source", Value: []byte("example.nomad")},
+		{Key: "jobs/example/deploy/timeout", Value: []byte("5m")},
+		{Key: "jobs/example/deploy/", Value: nil},
+		{Key: "jobs/other/deploy/timeout", Value: []byte("1m")},
+	})
+	defer s.Close()
+
+	values, err := ReadJobKey(context.Background(), consulStubClient(t, s), "jobs/example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"template/source": "example.nomad",
+		"deploy/timeout":  "5m",
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("got %v, want %v", values, want)
+	}
+}
+
+func TestReadJobKeyCancelled(t *testing.T) {
+	s := newConsulStub(consul.KVPairs{{Key: "jobs/example/deploy/timeout", Value: []byte("5m")}})
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := ReadJobKey(ctx, consulStubClient(t, s), "jobs/example"); err == nil {
+		t.Error("expected an error with a cancelled context")
+	}
+}
@@ -0,0 +1,99 @@
+package ops
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+// ScaleOptions are the options for scaling a task group
+type ScaleOptions struct {
+	Job      string // the name of the job
+	Group    string // the name of the task group
+	Count    int    // the new count, or the change in count if Relative
+	Relative bool   // whether Count is added to the current count
+}
+
+// ScaleResult is the result of scaling a task group
+type ScaleResult struct {
+	PreviousCount int
+	Count         int
+}
+
+// Scale sets the count of a job's task group
+func Scale(ctx context.Context, client *api.Client, o *ScaleOptions) (*ScaleResult, error) {
+	n, err := nomadClient(client)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	job, _, err := n.Jobs().Info(o.Job, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &ScaleResult{}
+	for _, tg := range job.TaskGroups {
+		if *tg.Name == o.Group && tg.Count != nil {
+			r.PreviousCount = *tg.Count
+		}
+	}
+
+	if o.Relative {
+		err = n.AdjustTaskGroupCount(ctx, job, o.Group, o.Count)
+		r.Count = r.PreviousCount + o.Count
+	} else {
+		err = n.SetTaskGroupCount(ctx, job, o.Group, o.Count)
+		r.Count = o.Count
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TaskGroupCount returns the current count of a job's task group
+func TaskGroupCount(ctx context.Context, client *api.Client, job, group string) (int, error) {
+	n, err := nomadClient(client)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	j, _, err := n.Jobs().Info(job, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, tg := range j.TaskGroups {
+		if *tg.Name == group {
+			return *tg.Count, nil
+		}
+	}
+	return 0, fmt.Errorf("could not find task group: %s", group)
+}
+
+// RestartOptions are the options for restarting a job
+type RestartOptions struct {
+	Job   string // the name of the job
+	Group string // the task group to restart, the whole job if empty
+}
+
+// Restart restarts a job, or one of its task groups
+func Restart(ctx context.Context, client *api.Client, o *RestartOptions) error {
+	n, err := nomadClient(client)
+	if err != nil {
+		return err
+	}
+
+	if o.Group == "" {
+		return n.RestartJob(ctx, o.Job)
+	}
+	return n.RestartTaskGroup(ctx, o.Job, o.Group)
+}
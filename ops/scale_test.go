@@ -0,0 +1,119 @@
+package ops
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestScale(t *testing.T) {
+	cases := []struct {
+		name     string
+		count    int
+		relative bool
+		previous int
+		want     int
+	}{
+		{"absolute", 5, false, 2, 5},
+		{"relative up", 3, true, 2, 5},
+		{"relative down", -1, true, 2, 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := newNomadStub(testJob(c.previous))
+			defer s.Close()
+
+			r, err := Scale(context.Background(), s.client(t), &ScaleOptions{
+				Job:      "example",
+				Group:    "web",
+				Count:    c.count,
+				Relative: c.relative,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.PreviousCount != c.previous || r.Count != c.want {
+				t.Errorf("got counts %d -> %d, want %d -> %d", r.PreviousCount, r.Count, c.previous, c.want)
+			}
+			if got := s.groupCounts("web"); !reflect.DeepEqual(got, []int{c.want}) {
+				t.Errorf("registered counts %v, want [%d]", got, c.want)
+			}
+		})
+	}
+}
+
+func TestScaleBelowZero(t *testing.T) {
+	s := newNomadStub(testJob(1))
+	defer s.Close()
+
+	_, err := Scale(context.Background(), s.client(t), &ScaleOptions{Job: "example", Group: "web", Count: -2, Relative: true})
+	if err == nil {
+		t.Fatal("expected an error scaling below zero")
+	}
+	if got := s.groupCounts("web"); len(got) != 0 {
+		t.Errorf("registered counts %v, want none", got)
+	}
+}
+
+func TestScaleCancelled(t *testing.T) {
+	s := newNomadStub(testJob(2))
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := Scale(ctx, s.client(t), &ScaleOptions{Job: "example", Group: "web", Count: 5}); err == nil {
+		t.Fatal("expected an error with a cancelled context")
+	}
+	if s.requests != 0 {
+		t.Errorf("got %d requests with a cancelled context, want none", s.requests)
+	}
+}
+
+func TestTaskGroupCount(t *testing.T) {
+	s := newNomadStub(testJob(3))
+	defer s.Close()
+
+	count, err := TaskGroupCount(context.Background(), s.client(t), "example", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("got count %d, want 3", count)
+	}
+
+	if _, err := TaskGroupCount(context.Background(), s.client(t), "example", "db"); err == nil {
+		t.Error("expected an error for a missing task group")
+	}
+}
+
+func TestRestartTaskGroup(t *testing.T) {
+	s := newNomadStub(testJob(2))
+	defer s.Close()
+
+	if err := Restart(context.Background(), s.client(t), &RestartOptions{Job: "example", Group: "web"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.groupCounts("web"); !reflect.DeepEqual(got, []int{0, 2}) {
+		t.Errorf("registered counts %v, want [0 2]", got)
+	}
+	if s.deregistered {
+		t.Error("restarting a task group deregistered the job")
+	}
+}
+
+func TestRestartJob(t *testing.T) {
+	s := newNomadStub(testJob(2))
+	defer s.Close()
+
+	if err := Restart(context.Background(), s.client(t), &RestartOptions{Job: "example"}); err != nil {
+		t.Fatal(err)
+	}
+	if !s.deregistered {
+		t.Error("restarting the job did not deregister it")
+	}
+	if got := s.groupCounts("web"); !reflect.DeepEqual(got, []int{2}) {
+		t.Errorf("registered counts %v, want [2]", got)
+	}
+}
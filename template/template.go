@@ -1,9 +1,11 @@
 package template
 
 import (
+	"context"
 	"io/ioutil"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/bdclark/nomadctl/logging"
 	ctConfig "github.com/hashicorp/consul-template/config"
@@ -19,6 +21,20 @@ type Template struct {
 	leftDelim     string
 	rightDelim    string
 	errMissingKey bool
+	consul        *ConsulConfig
+}
+
+// ConsulConfig represents the Consul connection used by template functions,
+// empty settings fall back to the standard Consul environment variables
+type ConsulConfig struct {
+	Address       string // address of the Consul agent, optionally with an "https://" scheme
+	Token         string // Consul ACL token
+	CACert        string // path to a CA cert to verify the agent cert
+	CAPath        string // path to a directory of CA certs to verify the agent cert
+	ClientCert    string // path to a client cert for TLS authentication
+	ClientKey     string // path to the private key matching the client cert
+	TLSServerName string // server name to use as the SNI host
+	TLSSkipVerify bool   // whether to skip verifying the agent cert
 }
 
 // NewTemplateInput represents the input to a new Template
@@ -29,6 +45,7 @@ type NewTemplateInput struct {
 	RightDelim    string
 	ErrMissingKey bool
 	Options       map[string]string
+	Consul        *ConsulConfig
 }
 
 // NewTemplate generates a new template
@@ -42,6 +59,7 @@ func NewTemplate(i *NewTemplateInput) (*Template, error) {
 	t.leftDelim = i.LeftDelim
 	t.rightDelim = i.RightDelim
 	t.errMissingKey = i.ErrMissingKey
+	t.consul = i.Consul
 
 	if i.Source != "" {
 		artifact := newGetterArtifact(i.Source, i.Options)
@@ -70,8 +88,9 @@ func NewTemplate(i *NewTemplateInput) (*Template, error) {
 	return &t, nil
 }
 
-// Render renders the template using Consul-Template
-func (t *Template) Render() ([]byte, error) {
+// Render renders the template using Consul-Template, stopping the
+// runner if the context is cancelled first
+func (t *Template) Render(ctx context.Context) ([]byte, error) {
 
 	config := &ctConfig.Config{
 		Templates: &ctConfig.TemplateConfigs{
@@ -83,6 +102,7 @@ func (t *Template) Render() ([]byte, error) {
 				ErrMissingKey: ctConfig.Bool(t.errMissingKey),
 			},
 		},
+		Consul: t.consulConfig(),
 	}
 
 	if logging.IsDebug() {
@@ -118,6 +138,44 @@ func (t *Template) Render() ([]byte, error) {
 			}
 		case err, _ = <-r.ErrCh:
 			return nil, err
+		case <-ctx.Done():
+			r.Stop()
+			return nil, ctx.Err()
 		}
 	}
 }
+
+// consulConfig returns the Consul-Template config for the template's
+// Consul connection, leaving unset settings to the environment
+func (t *Template) consulConfig() *ctConfig.ConsulConfig {
+	if t.consul == nil {
+		return nil
+	}
+
+	c := &ctConfig.ConsulConfig{SSL: &ctConfig.SSLConfig{}}
+	setString := func(p **string, s string) {
+		if s != "" {
+			*p = ctConfig.String(s)
+		}
+	}
+
+	address := t.consul.Address
+	if strings.HasPrefix(address, "https://") {
+		c.SSL.Enabled = ctConfig.Bool(true)
+	}
+	if i := strings.Index(address, "://"); i >= 0 {
+		address = address[i+3:]
+	}
+	setString(&c.Address, address)
+	setString(&c.Token, t.consul.Token)
+	setString(&c.SSL.CaCert, t.consul.CACert)
+	setString(&c.SSL.CaPath, t.consul.CAPath)
+	setString(&c.SSL.Cert, t.consul.ClientCert)
+	setString(&c.SSL.Key, t.consul.ClientKey)
+	setString(&c.SSL.ServerName, t.consul.TLSServerName)
+	if t.consul.TLSSkipVerify {
+		c.SSL.Verify = ctConfig.Bool(false)
+	}
+
+	return c
+}